@@ -0,0 +1,291 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/validator"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func (app *application) createAlertHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	clothe, err := app.models.Clothes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Kind string `json:"kind"`
+		Size string `json:"size"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	system, err := app.models.SizeSystems.Get(clothe.SizeSystemID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	alert := &data.Alert{
+		UserID:   user.ID,
+		ClotheID: id,
+		Kind:     input.Kind,
+		Size:     input.Size,
+	}
+	v := validator.New()
+
+	if data.ValidateAlert(v, alert, system); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Alerts.Insert(alert)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAlertNotNeeded):
+			v.AddError("size", "is already in stock")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, alert, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	alerts, err := app.models.Alerts.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"alerts": alerts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAlertHandler(w http.ResponseWriter, r *http.Request) {
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") != "me" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	alertID, err := app.readNamedIDParam(r, "alert_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Alerts.Delete(user.ID, alertID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "alert successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// alertQueue collects the clothes whose alerts have to be checked. A single
+// background job drains it, so the alerts of clothes changed in a burst are
+// fired and mailed as one batch. failures counts the batches in a row that
+// could not be fired.
+type alertQueue struct {
+	mu       sync.Mutex
+	clothes  map[int64]bool
+	running  bool
+	failures int
+}
+
+// A batch of alerts that cannot be fired is queued again after a backoff that
+// starts at alertBackoff and doubles with every failure. Its clothes are
+// dropped after alertRetries retries.
+const (
+	alertRetries = 5
+	alertBackoff = time.Second
+)
+
+// fireAlerts queues the clothe after it, or one of its variants, changed and
+// starts the alert job unless it is already running.
+func (app *application) fireAlerts(clotheID int64) {
+	q := &app.alerts
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.clothes == nil {
+		q.clothes = make(map[int64]bool)
+	}
+	q.clothes[clotheID] = true
+	if q.running {
+		return
+	}
+	q.running = true
+	app.background(app.runAlerts)
+}
+
+// runAlerts fires the alerts of the queued clothes until the queue is empty.
+// Alerts whose condition is now met are removed, every user gets one price
+// drop email per clothe and a single back in stock email listing all the sizes
+// of a clothe they were waiting for. The job stops early on shutdown, leaving
+// the clothes of a failed batch queued.
+func (app *application) runAlerts() {
+	q := &app.alerts
+	for {
+		q.mu.Lock()
+		clotheIDs := make([]int64, 0, len(q.clothes))
+		for id := range q.clothes {
+			clotheIDs = append(clotheIDs, id)
+		}
+		q.clothes = nil
+		if len(clotheIDs) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+
+		alerts, err := app.models.Alerts.Fire(clotheIDs)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"clothe_ids": fmt.Sprint(clotheIDs)})
+			if !app.requeueAlerts(clotheIDs) {
+				return
+			}
+			continue
+		}
+		q.mu.Lock()
+		q.failures = 0
+		q.mu.Unlock()
+		app.sendAlerts(alerts)
+	}
+}
+
+// requeueAlerts queues the clothes of a batch that failed again and waits
+// before it is retried. Once the retries are used up the clothes are dropped
+// instead. false is returned when the server shuts down while waiting, the job
+// is then stopped.
+func (app *application) requeueAlerts(clotheIDs []int64) bool {
+	q := &app.alerts
+	q.mu.Lock()
+	q.failures++
+	failures := q.failures
+	if failures > alertRetries {
+		q.failures = 0
+		q.mu.Unlock()
+		app.logger.PrintError(errors.New("alerts dropped after too many failures"), map[string]string{
+			"clothe_ids": fmt.Sprint(clotheIDs),
+		})
+		return true
+	}
+	if q.clothes == nil {
+		q.clothes = make(map[int64]bool)
+	}
+	for _, id := range clotheIDs {
+		q.clothes[id] = true
+	}
+	q.mu.Unlock()
+
+	timer := time.NewTimer(alertBackoff << (failures - 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-app.shutdown:
+		q.mu.Lock()
+		q.running = false
+		q.mu.Unlock()
+		return false
+	}
+}
+
+func (app *application) sendAlerts(alerts []*data.FiredAlert) {
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	var sizes []string
+	for i, alert := range alerts {
+		// The name of the clothe in the locale of the user.
+		clothe := &data.Clothe{ID: alert.ClotheID, Name: alert.ClotheName}
+		err = app.models.Translations.LocalizeClothes([]*data.Clothe{clothe}, alert.UserLocale)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		switch alert.Kind {
+		case data.AlertPriceDrop:
+			err = app.sendPriceDrop(alert, clothe.Name, rates)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		case data.AlertBackInStock:
+			sizes = append(sizes, alert.Size)
+		}
+		// Alerts come ordered by user and clothe, send the sizes once all
+		// alerts of the user for the clothe are collected.
+		last := i == len(alerts)-1 || alerts[i+1].UserID != alert.UserID || alerts[i+1].ClotheID != alert.ClotheID
+		if last && len(sizes) > 0 {
+			err = app.mailer.Send(alert.UserEmail, alert.UserLocale, "alert_back_in_stock.tmpl", map[string]any{
+				"name":       alert.UserName,
+				"clotheID":   alert.ClotheID,
+				"clotheName": clothe.Name,
+				"sizes":      sizes,
+			})
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+			sizes = nil
+		}
+	}
+}
+
+// sendPriceDrop mails a fired price_drop alert, with the prices in the currency
+// of the wallet of the user.
+func (app *application) sendPriceDrop(alert *data.FiredAlert, clotheName string, rates data.Rates) error {
+	oldPrice, err := rates.Convert(alert.Price, rates.Base, alert.UserCurrency)
+	if err != nil {
+		return err
+	}
+	newPrice, err := rates.Convert(alert.NewPrice, rates.Base, alert.UserCurrency)
+	if err != nil {
+		return err
+	}
+	return app.mailer.Send(alert.UserEmail, alert.UserLocale, "alert_price_drop.tmpl", map[string]any{
+		"name":       alert.UserName,
+		"clotheID":   alert.ClotheID,
+		"clotheName": clotheName,
+		"oldPrice":   rates.Format(oldPrice, alert.UserCurrency),
+		"newPrice":   rates.Format(newPrice, alert.UserCurrency),
+	})
+}
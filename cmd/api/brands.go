@@ -55,7 +55,28 @@ func (app *application) showBrandHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
-	brand, err := app.models.Brands.Get(id)
+	v := validator.New()
+	includeArchived, err := app.readIncludeArchived(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	currency := app.readCurrency(w, r, rates, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var brand *data.Brand
+	if includeArchived {
+		brand, err = app.models.Brands.GetIncludingArchived(id)
+	} else {
+		brand, err = app.models.Brands.Get(id)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -65,6 +86,21 @@ func (app *application) showBrandHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	brand.Stats, err = app.models.Brands.GetStats(brand.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.convertBrandStats(brand.Stats, rates, currency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Translations.LocalizeBrands([]*data.Brand{brand}, app.contextGetLocale(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	err = app.writeJSON(w, http.StatusOK, brand, nil)
 	if err != nil {
@@ -110,8 +146,14 @@ func (app *application) updateBrandHandler(w http.ResponseWriter, r *http.Reques
 	if input.Description != nil {
 		brand.Description = *input.Description
 	}
-	if input.ImageURL != nil {
+	// An uploaded image is replaced by a linked one, its files are deleted
+	// once the brand is saved.
+	var oldKey string
+	if input.ImageURL != nil && *input.ImageURL != brand.ImageURL {
 		brand.ImageURL = *input.ImageURL
+		oldKey = brand.ImageStorageKey
+		brand.ImageStorageKey = ""
+		brand.Srcset = nil
 	}
 
 	v := validator.New()
@@ -124,6 +166,9 @@ func (app *application) updateBrandHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if oldKey != "" {
+		app.deleteStoredImage(oldKey)
+	}
 	err = app.writeJSON(w, http.StatusOK, brand, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -142,25 +187,101 @@ func (app *application) deleteBrandHandler(w http.ResponseWriter, r *http.Reques
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrBrandInUse):
+			app.brandInUseResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "brand successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "brand successfully archived"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreBrandHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	brand, err := app.models.Brands.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, brand, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) listBrandsHandler(w http.ResponseWriter, r *http.Request) {
-	brands, err := app.models.Brands.GetAll()
+	var input struct {
+		Name    string
+		Country string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Country = app.readString(qs, "country", "")
+
+	includeArchived, err := app.readIncludeArchived(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "country", "-id", "-name", "-country"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	brands, metadata, err := app.models.Brands.GetAll(input.Name, input.Country, includeArchived, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, brands, nil)
+	err = app.models.Translations.LocalizeBrands(brands, app.contextGetLocale(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"brands": brands, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBrandClothesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Brands.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
+	app.listClothes(w, r, data.ClotheQuery{BrandID: id}, envelope{})
 }
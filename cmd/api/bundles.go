@@ -0,0 +1,387 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// prepareBundles shows the prices of the bundles, and their clothes, in the
+// currency and the locale of the request.
+func (app *application) prepareBundles(r *http.Request, bundles []*data.Bundle, rates data.Rates, currency string) error {
+	var clothes []*data.Clothe
+	for _, bundle := range bundles {
+		if bundle.Price != nil {
+			price, err := rates.Convert(*bundle.Price, rates.Base, currency)
+			if err != nil {
+				return err
+			}
+			bundle.Price = &price
+		}
+		regular, err := rates.Convert(bundle.RegularPrice, rates.Base, currency)
+		if err != nil {
+			return err
+		}
+		total, err := rates.Convert(bundle.BundlePrice, rates.Base, currency)
+		if err != nil {
+			return err
+		}
+		bundle.RegularPrice, bundle.BundlePrice = regular, total
+		bundle.Currency = currency
+		clothes = append(clothes, bundle.Clothes...)
+	}
+	err := app.convertClothes(clothes, rates, currency)
+	if err != nil {
+		return err
+	}
+	return app.models.Translations.LocalizeClothes(clothes, app.contextGetLocale(r))
+}
+
+// setClotheBundles lists on every clothe the bundles on sale it is part of.
+func (app *application) setClotheBundles(clothes []*data.Clothe) error {
+	if len(clothes) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(clothes))
+	for i, clothe := range clothes {
+		ids[i] = clothe.ID
+	}
+	bundles, err := app.models.Bundles.GetAllForClothes(ids)
+	if err != nil {
+		return err
+	}
+	for _, clothe := range clothes {
+		clothe.Bundles = bundles[clothe.ID]
+	}
+	return nil
+}
+
+func (app *application) listBundlesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ClotheID int64
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.ClotheID = app.readInt(qs, "clothe_id", 0, v)
+	v.Check(input.ClotheID >= 0, "clothe_id", "must be a positive integer")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "-id", "-name"}
+
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	currency := app.readCurrency(w, r, rates, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bundles, metadata, err := app.models.Bundles.GetAll(input.ClotheID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.prepareBundles(r, bundles, rates, currency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"bundles": bundles, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showBundleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	v := validator.New()
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	currency := app.readCurrency(w, r, rates, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bundle, err := app.models.Bundles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.prepareBundles(r, []*data.Bundle{bundle}, rates, currency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"bundle": bundle}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createBundleHandler(w http.ResponseWriter, r *http.Request) {
+	// Either price, a fixed price for the whole bundle, or percent_off.
+	var input struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		Price       *int64  `json:"price"`
+		PercentOff  *int64  `json:"percent_off"`
+		ClotheIDs   []int64 `json:"clothe_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	bundle := &data.Bundle{
+		Name:        input.Name,
+		Description: input.Description,
+		Price:       input.Price,
+		PercentOff:  input.PercentOff,
+		ClotheIDs:   input.ClotheIDs,
+	}
+	v := validator.New()
+
+	if data.ValidateBundle(v, bundle); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Bundles.Insert(bundle)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownClothe):
+			v.AddError("clothe_ids", "must refer to clothes on sale")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeSavedBundle(w, r, bundle.ID, http.StatusCreated)
+}
+
+func (app *application) updateBundleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	bundle, err := app.models.Bundles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Setting price drops percent_off and the other way around.
+	var input struct {
+		Name        *string  `json:"name"`
+		Description *string  `json:"description"`
+		Price       *int64   `json:"price"`
+		PercentOff  *int64   `json:"percent_off"`
+		ClotheIDs   *[]int64 `json:"clothe_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		bundle.Name = *input.Name
+	}
+	if input.Description != nil {
+		bundle.Description = *input.Description
+	}
+	if input.Price != nil {
+		bundle.Price = input.Price
+		bundle.PercentOff = input.PercentOff
+	} else if input.PercentOff != nil {
+		bundle.Price = nil
+		bundle.PercentOff = input.PercentOff
+	}
+	if input.ClotheIDs != nil {
+		bundle.ClotheIDs = *input.ClotheIDs
+	}
+
+	v := validator.New()
+	if data.ValidateBundle(v, bundle); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Bundles.Update(bundle)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownClothe):
+			v.AddError("clothe_ids", "must refer to clothes on sale")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeSavedBundle(w, r, bundle.ID, http.StatusOK)
+}
+
+// writeSavedBundle responds with the bundle as saved, its prices in the base
+// currency.
+func (app *application) writeSavedBundle(w http.ResponseWriter, r *http.Request, id int64, status int) {
+	bundle, err := app.models.Bundles.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	bundle.Currency = rates.Base
+	for _, clothe := range bundle.Clothes {
+		clothe.Currency = rates.Base
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/bundles/%d", bundle.ID))
+	err = app.writeJSON(w, status, envelope{"bundle": bundle}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteBundleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Bundles.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "bundle successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purchaseBundleHandler buys every clothe of the bundle at the bundle price.
+// The sizes are keyed by clothe id, as are the optional variants to buy
+// instead of the clothes themselves, e.g.
+// {"sizes": {"12": "M", "40": "42"}, "variants": {"12": 3}}.
+func (app *application) purchaseBundleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	bundle, err := app.models.Bundles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Sizes    map[int64]string `json:"sizes"`
+		Variants map[int64]int64  `json:"variants"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Sizes are matched case-insensitively, like when a single clothe is
+	// bought.
+	for id, size := range input.Sizes {
+		input.Sizes[id] = strings.ToUpper(size)
+	}
+	err = app.setClotheVariants(bundle.Clothes, false)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateBundlePurchase(v, bundle, input.Sizes, input.Variants); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	charged, err := app.models.Bundles.Purchase(bundle.ID, user, input.Sizes, input.Variants, rates)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrBundleUnavailable):
+			v.AddError("sizes", "some clothes of the bundle are no longer available in the chosen sizes")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrNotEnoughMoney):
+			v.AddError("money", "not enough money to buy the bundle")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"message":  "bundle successfully added to the cart",
+		"charged":  charged,
+		"currency": user.Currency,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -24,48 +24,84 @@ func (app *application) addToCartHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	qs := r.URL.Query()
+	v := validator.New()
+	// A variant is bought at its own price and in its own sizes, without one
+	// the clothe itself is bought.
+	basePrice, sizes := clothe.Price, clothe.Sizes
+	variantID := app.readInt(qs, "variant_id", 0, v)
+	if variantID != 0 {
+		variant, err := app.models.Variants.Get(clothe.ID, variantID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("variant_id", "must be a variant of the clothe")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		} else {
+			sizes = variant.Sizes
+			if variant.Price != nil {
+				basePrice = *variant.Price
+			}
+		}
+	}
+	// The size is optional, when given it is kept for size recommendations.
+	// Sizes are stored in upper case, like the listing filter expects them.
+	size := strings.ToUpper(app.readString(qs, "size", ""))
+	if size != "" && v.Valid() {
+		v.Check(validator.PermittedValue(size, upperSizes(sizes)...), "size", "must be one of the sizes of the clothe")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	authorizationHeader := r.Header.Get("Authorization")
+	user := app.contextGetUser(r)
 
-	headerParts := strings.Split(authorizationHeader, " ")
-	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		app.invalidAuthenticationTokenResponse(w, r)
+	// Clothes are priced in the base currency, the purchase settles in the
+	// currency of the wallet.
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-
-	token := headerParts[1]
-	v := validator.New()
-	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-		app.invalidAuthenticationTokenResponse(w, r)
+	price, err := rates.Convert(basePrice, rates.Base, user.Currency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+	err = app.models.Carts.Purchase(user, clothe.ID, price, size)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrNotEnoughMoney):
+			v.AddError("money", "not enough money to buy the clothe")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	err = app.models.Users.UpdateMoney(user, clothe.Price)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
 
-	err = app.models.Carts.AddClotheForCart(user.ID, *clothe)
+	env := envelope{
+		"message":  "clothe successfully added to the cart",
+		"charged":  price,
+		"currency": user.Currency,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
+}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "clothe successfully added to the cart"}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+func upperSizes(sizes []string) []string {
+	upper := make([]string, len(sizes))
+	for i, size := range sizes {
+		upper[i] = strings.ToUpper(size)
 	}
+	return upper
 }
 
 func (app *application) showCartHandler(w http.ResponseWriter, r *http.Request) {
@@ -73,34 +109,12 @@ func (app *application) showCartHandler(w http.ResponseWriter, r *http.Request)
 	var response struct {
 		Name      string  `json:"name"`
 		Money     int64   `json:"money"`
+		Currency  string  `json:"currency"`
 		ClothesID []int64 `json:"clothes_id"`
 	}
 
-	authorizationHeader := r.Header.Get("Authorization")
-
-	headerParts := strings.Split(authorizationHeader, " ")
-	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		app.invalidAuthenticationTokenResponse(w, r)
-		return
-	}
-
-	token := headerParts[1]
-	v := validator.New()
-	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-		app.invalidAuthenticationTokenResponse(w, r)
-		return
-	}
+	user := app.contextGetUser(r)
 
-	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidAuthenticationTokenResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
 	clothes, err := app.models.Carts.GetById(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -109,6 +123,7 @@ func (app *application) showCartHandler(w http.ResponseWriter, r *http.Request)
 
 	response.Name = user.Name
 	response.Money = user.Money
+	response.Currency = user.Currency
 	response.ClothesID = clothes
 
 	err = app.writeJSON(w, http.StatusOK, response, nil)
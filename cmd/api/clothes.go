@@ -6,19 +6,24 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 func (app *application) createClotheHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Name     string   `json:"name"`
-		Price    int64    `json:"price"`
-		Brand    string   `json:"brand"`
-		Color    string   `json:"color"`
-		Sizes    []string `json:"sizes"`
-		Sex      string   `json:"sex"`
-		Type     string   `json:"type"`
-		ImageURL string   `json:"image_url"`
+		Name        string   `json:"name"`
+		Price       int64    `json:"price"`
+		BrandID     int64    `json:"brand_id"`
+		Color       string   `json:"color"`
+		Sizes       []string `json:"sizes"`
+		Sex         string   `json:"sex"`
+		CategoryID  int64    `json:"category_id"`
+		ImageURL    string   `json:"image_url"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+		// SizeSystemID defaults to the alpha size system.
+		SizeSystemID int64 `json:"size_system_id"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -28,18 +33,39 @@ func (app *application) createClotheHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	clothe := &data.Clothe{
-		Name:     input.Name,
-		Price:    input.Price,
-		Brand:    input.Brand,
-		Color:    input.Color,
-		Sizes:    input.Sizes,
-		Sex:      input.Sex,
-		Type:     input.Type,
-		ImageURL: input.ImageURL,
+		Name:        input.Name,
+		Price:       input.Price,
+		BrandID:     input.BrandID,
+		Color:       input.Color,
+		Sizes:       input.Sizes,
+		Sex:         input.Sex,
+		CategoryID:  input.CategoryID,
+		ImageURL:    input.ImageURL,
+		Description: input.Description,
+		Tags:        input.Tags,
+	}
+	system, err := app.clotheSizeSystem(clothe, input.SizeSystemID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 	v := validator.New()
 
-	if data.ValidateClothe(v, clothe); !v.Valid() {
+	if data.ValidateClothe(v, clothe, system); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.setClotheCategory(clothe, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.setClotheBrand(clothe, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -48,6 +74,11 @@ func (app *application) createClotheHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	err = app.setBaseCurrency(clothe)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/clothes/%d", clothe.ID))
 	err = app.writeJSON(w, http.StatusCreated, clothe, headers)
@@ -63,7 +94,28 @@ func (app *application) showClotheHandler(w http.ResponseWriter, r *http.Request
 		app.notFoundResponse(w, r)
 		return
 	}
-	clothe, err := app.models.Clothes.Get(id)
+	v := validator.New()
+	includeArchived, err := app.readIncludeArchived(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	currency := app.readCurrency(w, r, rates, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var clothe *data.Clothe
+	if includeArchived {
+		clothe, err = app.models.Clothes.GetIncludingArchived(id)
+	} else {
+		clothe, err = app.models.Clothes.Get(id)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -73,6 +125,21 @@ func (app *application) showClotheHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
+	err = app.setClotheVariants([]*data.Clothe{clothe}, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.convertClothes([]*data.Clothe{clothe}, rates, currency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Translations.LocalizeClothes([]*data.Clothe{clothe}, app.contextGetLocale(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	// Encode the struct to JSON and send it as the HTTP response.
 	err = app.writeJSON(w, http.StatusOK, clothe, nil)
@@ -98,14 +165,17 @@ func (app *application) updateClotheHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	var input struct {
-		Name     *string  `json:"name"`
-		Price    *int64   `json:"price"`
-		Brand    *string  `json:"brand"`
-		Color    *string  `json:"color"`
-		Sizes    []string `json:"sizes"`
-		Sex      *string  `json:"sex"`
-		Type     *string  `json:"type"`
-		ImageURL *string  `json:"image_url"`
+		Name         *string  `json:"name"`
+		Price        *int64   `json:"price"`
+		BrandID      *int64   `json:"brand_id"`
+		Color        *string  `json:"color"`
+		Sizes        []string `json:"sizes"`
+		Sex          *string  `json:"sex"`
+		CategoryID   *int64   `json:"category_id"`
+		ImageURL     *string  `json:"image_url"`
+		Description  *string  `json:"description"`
+		Tags         []string `json:"tags"`
+		SizeSystemID *int64   `json:"size_system_id"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -120,8 +190,8 @@ func (app *application) updateClotheHandler(w http.ResponseWriter, r *http.Reque
 	if input.Price != nil {
 		clothe.Price = *input.Price
 	}
-	if input.Brand != nil {
-		clothe.Brand = *input.Brand
+	if input.BrandID != nil {
+		clothe.BrandID = *input.BrandID
 	}
 	if input.Color != nil {
 		clothe.Color = *input.Color
@@ -132,15 +202,58 @@ func (app *application) updateClotheHandler(w http.ResponseWriter, r *http.Reque
 	if input.Sex != nil {
 		clothe.Sex = *input.Sex
 	}
-	if input.Type != nil {
-		clothe.Type = *input.Type
+	if input.CategoryID != nil {
+		clothe.CategoryID = *input.CategoryID
 	}
 	if input.ImageURL != nil {
 		clothe.ImageURL = *input.ImageURL
 	}
+	if input.Description != nil {
+		clothe.Description = *input.Description
+	}
+	if input.Tags != nil {
+		clothe.Tags = input.Tags
+	}
+	previousSizeSystemID := clothe.SizeSystemID
+	sizeSystemID := clothe.SizeSystemID
+	if input.SizeSystemID != nil {
+		sizeSystemID = *input.SizeSystemID
+	}
 
+	system, err := app.clotheSizeSystem(clothe, sizeSystemID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	v := validator.New()
-	if data.ValidateClothe(v, clothe); !v.Valid() {
+	if data.ValidateClothe(v, clothe, system); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// The variants keep their sizes, so a new size system has to permit them.
+	if clothe.SizeSystemID != previousSizeSystemID {
+		variants, err := app.models.Variants.GetAllForClothes([]int64{clothe.ID})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, variant := range variants[clothe.ID] {
+			for _, size := range variant.Sizes {
+				v.Check(system.Permits(size), "size_system_id", "must permit the sizes of the variants of the clothe")
+			}
+		}
+	}
+	err = app.setClotheCategory(clothe, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.setClotheBrand(clothe, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -149,6 +262,12 @@ func (app *application) updateClotheHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.fireAlerts(clothe.ID)
+	err = app.setBaseCurrency(clothe)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	err = app.writeJSON(w, http.StatusOK, clothe, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -172,72 +291,328 @@ func (app *application) deleteClotheHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "clothe successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "clothe successfully archived"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreClotheHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	clothe, err := app.models.Clothes.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.fireAlerts(clothe.ID)
+	err = app.setBaseCurrency(clothe)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, clothe, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) listClothesHandler(w http.ResponseWriter, r *http.Request) {
+	app.listClothes(w, r, data.ClotheQuery{}, envelope{})
+}
+
+// listClothes writes the filtered and paginated clothes listing. The BrandID
+// and CollectionID of scope restrict it to the catalogue of a brand or to the
+// members of a collection, env holds extra fields of the response. With
+// facets=true the facet counts for the same filters are added to the response.
+func (app *application) listClothes(w http.ResponseWriter, r *http.Request, scope data.ClotheQuery, env envelope) {
 
 	var input struct {
-		Name     string
-		Brand    string
-		PriceMax int64
-		PriceMin int64
-		Sizes    []string
-		Color    string
-		Type     string
-		Sex      string
+		Search     string
+		Brands     []string
+		PriceMax   int64
+		PriceMin   int64
+		Sizes      []string
+		SizesMatch string
+		Colors     []string
+		Category   string
+		SizeSystem string
+		Sex        string
+		RatingMin  float64
+		Tags       []string
 		data.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 
-	input.Name = app.readString(qs, "name", "")
-	input.Brand = app.readString(qs, "brand", "")
-	input.PriceMax = app.readInt(qs, "price_max", 10000000, v)
+	// name is the older spelling of q and is still accepted.
+	input.Search = app.readString(qs, "q", app.readString(qs, "name", ""))
+	input.Brands = app.readCSV(qs, "brand", []string{})
+	input.PriceMax = app.readInt(qs, "price_max", 0, v)
 	input.PriceMin = app.readInt(qs, "price_min", 0, v)
-	input.Name = app.readString(qs, "name", "")
 	input.Sizes = app.readCSV(qs, "sizes", []string{})
+	input.SizesMatch = app.readString(qs, "sizes_match", "all")
+	input.Colors = app.readCSV(qs, "color", []string{})
 	input.Sex = app.readString(qs, "sex", "")
-	input.Sex = app.readString(qs, "sex", "")
+	input.Category = app.readString(qs, "category", "")
+	input.SizeSystem = app.readString(qs, "size_system", "")
+	input.RatingMin = app.readFloat(qs, "rating_min", 0, v)
+	input.Tags = app.readCSV(qs, "tags", []string{})
+	withFacets := app.readBool(qs, "facets", false, v)
+
+	includeArchived, err := app.readIncludeArchived(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	currency := app.readCurrency(w, r, rates, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = 20
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "price", "sex", "brand", "-id", "-name", "-price", "-sex", "-brand"}
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// relevance always lists the best matches first, it has no descending
+	// variant.
+	input.Filters.SortSafelist = []string{"id", "name", "price", "sex", "brand", "rating", "relevance",
+		"-id", "-name", "-price", "-sex", "-brand", "-rating"}
+	// A collection is listed in its curated order unless asked otherwise.
+	defaultSort := "id"
+	if scope.CollectionID != 0 {
+		defaultSort = "position"
+		input.Filters.SortSafelist = append(input.Filters.SortSafelist, "position", "-position")
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
 
+	if input.Filters.Sort == "relevance" {
+		v.Check(input.Search != "", "sort", "relevance requires a search term in q")
+		v.Check(input.Filters.Cursor == "", "cursor", "is not supported when sorting by relevance")
+	}
+	if strings.TrimPrefix(input.Filters.Sort, "-") == "position" {
+		v.Check(input.Filters.Cursor == "", "cursor", "is not supported when sorting by position")
+	}
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	allBrands := app.models.Brands.GetAllBrandNames()
-	allBrands = append(allBrands, "")
+	// Sizes are checked against the selected size system, or against every
+	// size system when none is selected.
+	var systems []*data.SizeSystem
+	if input.SizeSystem != "" {
+		system, err := app.models.SizeSystems.GetBySlug(input.SizeSystem)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("size_system", "unknown size system")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		systems = []*data.SizeSystem{system}
+	} else {
+		systems, err = app.models.SizeSystems.GetAll()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	sizesSafelist := []string{""}
+	for _, system := range systems {
+		sizesSafelist = append(sizesSafelist, system.Sizes...)
+	}
+	var sizeSystemID int64
+	if input.SizeSystem != "" {
+		sizeSystemID = systems[0].ID
+	}
 
 	keys := data.Keys{
-		PriceMax:       input.PriceMax,
-		PriceMin:       input.PriceMin,
-		Brand:          input.Brand,
-		Sizes:          input.Sizes,
-		SizesSafelist:  []string{"XS", "S", "M", "L", "XL", ""},
-		BrandsSafelist: allBrands,
+		PriceMax:      input.PriceMax,
+		PriceMin:      input.PriceMin,
+		Sizes:         input.Sizes,
+		SizesMatch:    input.SizesMatch,
+		SizesSafelist: sizesSafelist,
+		Sex:           input.Sex,
+		RatingMin:     input.RatingMin,
 	}
 
 	if data.ValidateKeys(v, keys); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Price bounds are given in the display currency, clothes are priced in
+	// the base currency.
+	input.PriceMin, err = rates.Convert(input.PriceMin, currency, rates.Base)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.PriceMax, err = rates.Convert(input.PriceMax, currency, rates.Base)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var categoryID int64
+	if input.Category != "" {
+		category, err := app.models.Categories.GetBySlug(input.Category)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("category", "unknown category")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		categoryID = category.ID
+	}
 
-	clothes, err := app.models.Clothes.GetAll(input.Name, input.Brand, input.PriceMax, input.PriceMin,
-		input.Sizes, input.Color, input.Type, input.Sex, input.Filters)
+	query := data.ClotheQuery{
+		Search:          input.Search,
+		Brands:          input.Brands,
+		BrandID:         scope.BrandID,
+		PriceMax:        input.PriceMax,
+		PriceMin:        input.PriceMin,
+		Sizes:           input.Sizes,
+		SizesMatch:      input.SizesMatch,
+		Colors:          input.Colors,
+		CategoryID:      categoryID,
+		SizeSystemID:    sizeSystemID,
+		Sex:             input.Sex,
+		RatingMin:       input.RatingMin,
+		IncludeArchived: includeArchived,
+		Tags:            input.Tags,
+		CollectionID:    scope.CollectionID,
+	}
+
+	clothes, metadata, err := app.models.Clothes.GetAll(query, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.setClotheVariants(clothes, false)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, clothes, nil)
+	err = app.setClotheBundles(clothes)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.convertClothes(clothes, rates, currency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Translations.LocalizeClothes(clothes, app.contextGetLocale(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env["clothes"] = clothes
+	env["metadata"] = metadata
+	if withFacets {
+		// The price facet is counted in the display currency as well.
+		bounds := make([]int64, len(data.PriceBuckets))
+		for i, bucket := range data.PriceBuckets {
+			bounds[i], err = rates.Convert(bucket, currency, rates.Base)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		env["facets"], err = app.models.Clothes.GetFacets(query, bounds)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setClotheBrand checks that the clothe refers to a brand that is on sale and
+// fills in the embedded brand info.
+func (app *application) setClotheBrand(clothe *data.Clothe, v *validator.Validator) error {
+	brand, err := app.models.Brands.Get(clothe.BrandID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("brand_id", "must refer to an existing brand")
+			return nil
+		default:
+			return err
+		}
+	}
+	clothe.Brand = data.BrandInfo{
+		ID:       brand.ID,
+		Name:     brand.Name,
+		Country:  brand.Country,
+		ImageURL: brand.ImageURL,
+	}
+	return nil
+}
+
+// clotheSizeSystem loads the size system with the given id, or the default one
+// when id is zero, and sets it on the clothe. A nil system is returned when it
+// does not exist, which ValidateClothe reports.
+func (app *application) clotheSizeSystem(clothe *data.Clothe, id int64) (*data.SizeSystem, error) {
+	var system *data.SizeSystem
+	var err error
+	if id == 0 {
+		system, err = app.models.SizeSystems.GetBySlug(data.DefaultSizeSystem)
+	} else {
+		system, err = app.models.SizeSystems.Get(id)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	clothe.SizeSystemID = system.ID
+	clothe.SizeSystem = system.Slug
+	return system, nil
+}
+
+// setClotheCategory checks that the clothe refers to an existing category and
+// fills in the embedded category info.
+func (app *application) setClotheCategory(clothe *data.Clothe, v *validator.Validator) error {
+	category, err := app.models.Categories.Get(clothe.CategoryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("category_id", "unknown category")
+			return nil
+		default:
+			return err
+		}
+	}
+	clothe.Category = data.CategoryInfo{
+		ID:   category.ID,
+		Name: category.Name,
+		Slug: category.Slug,
 	}
+	return nil
 }
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/validator"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"time"
+)
+
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "starts_at", "-id", "-name", "-starts_at"}
+
+	// Admins see scheduled and expired collections too.
+	includeInactive, err := app.readIncludeInactive(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collections, metadata, err := app.models.Collections.GetAll(includeInactive, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCollectionHandler lists the clothes of the collection with the filters,
+// sorting and pagination of the clothes listing, in the curated order by
+// default. Collections outside their dates are only shown to admins asking
+// for include_inactive=true, include_archived only lists archived clothes.
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	v := validator.New()
+	includeInactive, err := app.readIncludeInactive(r, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collection, err := app.models.Collections.GetBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !collection.Active(time.Now()) && !includeInactive {
+		app.notFoundResponse(w, r)
+		return
+	}
+	app.listClothes(w, r, data.ClotheQuery{CollectionID: collection.ID}, envelope{"collection": collection})
+}
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug         string     `json:"slug"`
+		Name         string     `json:"name"`
+		Description  string     `json:"description"`
+		HeroImageURL string     `json:"hero_image_url"`
+		StartsAt     *time.Time `json:"starts_at"`
+		EndsAt       *time.Time `json:"ends_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		Slug:         input.Slug,
+		Name:         input.Name,
+		Description:  input.Description,
+		HeroImageURL: input.HeroImageURL,
+		StartsAt:     input.StartsAt,
+		EndsAt:       input.EndsAt,
+	}
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Collections.Insert(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a collection with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%s", collection.Slug))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	collection, err := app.models.Collections.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// An empty starts_at or ends_at removes that bound.
+	var input struct {
+		Slug         *string `json:"slug"`
+		Name         *string `json:"name"`
+		Description  *string `json:"description"`
+		HeroImageURL *string `json:"hero_image_url"`
+		StartsAt     *string `json:"starts_at"`
+		EndsAt       *string `json:"ends_at"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Slug != nil {
+		collection.Slug = *input.Slug
+	}
+	if input.Name != nil {
+		collection.Name = *input.Name
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+	oldKey := ""
+	if input.HeroImageURL != nil {
+		// A linked image replaces an uploaded one.
+		oldKey = collection.HeroImageStorageKey
+		collection.HeroImageURL = *input.HeroImageURL
+		collection.HeroImageStorageKey = ""
+		collection.HeroSrcset = nil
+	}
+	if input.StartsAt != nil {
+		collection.StartsAt = parseCollectionDate(v, "starts_at", *input.StartsAt)
+	}
+	if input.EndsAt != nil {
+		collection.EndsAt = parseCollectionDate(v, "ends_at", *input.EndsAt)
+	}
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Collections.Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a collection with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if oldKey != "" {
+		app.deleteStoredImage(oldKey)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// parseCollectionDate reads an RFC 3339 timestamp, the empty string standing
+// for no date.
+func parseCollectionDate(v *validator.Validator, key, value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+	return &t
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	collection, err := app.models.Collections.Get(id)
+	if err == nil {
+		err = app.models.Collections.Delete(id)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if collection.HeroImageStorageKey != "" {
+		app.deleteStoredImage(collection.HeroImageStorageKey)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replaceCollectionClothesHandler sets the clothes of the collection, in the
+// order they are listed in, e.g. {"clothe_ids": [12, 40, 7]}.
+func (app *application) replaceCollectionClothesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ClotheIDs []int64 `json:"clothe_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateCollectionClothes(v, input.ClotheIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Collections.SetClothes(id, input.ClotheIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnknownClothe):
+			v.AddError("clothe_ids", "must refer to clothes on sale")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"clothe_ids": input.ClotheIDs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) uploadCollectionHeroImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	collection, err := app.models.Collections.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	upload, err := app.readImageUpload(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	_, ok := data.ImageContentTypes[upload.contentType]
+	v.Check(ok, "image", "must be a JPEG, PNG, WebP or GIF image")
+	app.checkImageUpload(v, upload)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	oldKey := collection.HeroImageStorageKey
+	collection.HeroImageStorageKey, collection.HeroImageURL, err = app.storeImage(r.Context(), fmt.Sprintf("collections/%d", id), upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	collection.HeroSrcset = nil
+
+	err = app.models.Collections.Update(collection)
+	if err != nil {
+		app.deleteStoredImage(collection.HeroImageStorageKey)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if oldKey != "" {
+		app.deleteStoredImage(oldKey)
+	}
+	key := collection.HeroImageStorageKey
+	app.generateVariants(key, upload.data, func(srcset data.Srcset) error {
+		return app.models.Collections.SetHeroImageVariants(id, key, srcset)
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
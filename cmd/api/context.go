@@ -2,14 +2,20 @@ package main
 
 import (
 	"clothing-store/internal/data"
+	"clothing-store/internal/i18n"
 	"context"
 	"net/http"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey     = contextKey("user")
+	realUserContextKey = contextKey("realUser")
+	localeContextKey   = contextKey("locale")
+)
 
+// contextSetUser stores the effective user, i.e. the user the request acts as.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
@@ -22,3 +28,37 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetRealUser stores the user who actually authenticated. It only differs
+// from the effective user when an admin is impersonating somebody.
+func (app *application) contextSetRealUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), realUserContextKey, user)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRealUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(realUserContextKey).(*data.User)
+	if !ok {
+		return app.contextGetUser(r)
+	}
+	return user
+}
+
+func (app *application) contextIsImpersonating(r *http.Request) bool {
+	return app.contextGetRealUser(r) != app.contextGetUser(r)
+}
+
+func (app *application) contextSetLocale(r *http.Request, locale string) *http.Request {
+	ctx := context.WithValue(r.Context(), localeContextKey, locale)
+	return r.WithContext(ctx)
+}
+
+// contextGetLocale returns the locale of the response, the default locale
+// when the request did not go through the localize middleware.
+func (app *application) contextGetLocale(r *http.Request) string {
+	locale, ok := r.Context().Value(localeContextKey).(string)
+	if !ok {
+		return i18n.Default
+	}
+	return locale
+}
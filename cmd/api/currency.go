@@ -0,0 +1,162 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/validator"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+)
+
+// readCurrency returns the currency prices are shown in: the currency query
+// parameter, else the first currency of the Accept-Currency header, else the
+// base currency.
+func (app *application) readCurrency(w http.ResponseWriter, r *http.Request, rates data.Rates, v *validator.Validator) string {
+	w.Header().Add("Vary", "Accept-Currency")
+
+	currency := app.readString(r.URL.Query(), "currency", "")
+	if currency == "" {
+		header := r.Header.Get("Accept-Currency")
+		currency, _, _ = strings.Cut(header, ",")
+		currency, _, _ = strings.Cut(currency, ";")
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return rates.Base
+	}
+	v.Check(rates.Supports(currency), "currency", "unsupported currency")
+	return currency
+}
+
+// convertClothes shows the prices of the clothes, and of their variants, in the
+// currency.
+func (app *application) convertClothes(clothes []*data.Clothe, rates data.Rates, currency string) error {
+	for _, clothe := range clothes {
+		price, err := rates.Convert(clothe.Price, rates.Base, currency)
+		if err != nil {
+			return err
+		}
+		clothe.Price = price
+		clothe.Currency = currency
+		for i := range clothe.Variants {
+			if clothe.Variants[i].Price == nil {
+				continue
+			}
+			price, err := rates.Convert(*clothe.Variants[i].Price, rates.Base, currency)
+			if err != nil {
+				return err
+			}
+			clothe.Variants[i].Price = &price
+		}
+	}
+	return nil
+}
+
+// convertBrandStats shows the price range of the stats in the currency.
+func (app *application) convertBrandStats(stats *data.BrandStats, rates data.Rates, currency string) error {
+	priceMin, err := rates.Convert(stats.PriceMin, rates.Base, currency)
+	if err != nil {
+		return err
+	}
+	priceMax, err := rates.Convert(stats.PriceMax, rates.Base, currency)
+	if err != nil {
+		return err
+	}
+	stats.PriceMin, stats.PriceMax = priceMin, priceMax
+	stats.Currency = currency
+	return nil
+}
+
+func (app *application) listExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.ExchangeRates.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rates": rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) putExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	// The rate is a decimal string, e.g. "0.92", to avoid float rounding.
+	var input struct {
+		Rate       string `json:"rate"`
+		MinorUnits *int   `json:"minor_units"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rate := &data.ExchangeRate{
+		Currency:   strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("currency")),
+		Rate:       input.Rate,
+		MinorUnits: 2,
+	}
+	if input.MinorUnits != nil {
+		rate.MinorUnits = *input.MinorUnits
+	}
+	v := validator.New()
+
+	if data.ValidateExchangeRate(v, rate); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.ExchangeRates.Upsert(rate)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrBaseCurrency):
+			v.AddError("currency", "the base currency cannot be changed")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rate": rate}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	currency := strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("currency"))
+
+	err := app.models.ExchangeRates.Delete(currency)
+	if err != nil {
+		v := validator.New()
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrBaseCurrency):
+			v.AddError("currency", "the base currency cannot be deleted")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCurrencyInUse):
+			v.AddError("currency", "wallets are kept in this currency")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "exchange rate successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setBaseCurrency labels the prices of a clothe that is shown unconverted, as
+// in the responses of the admin endpoints.
+func (app *application) setBaseCurrency(clothe *data.Clothe) error {
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		return err
+	}
+	clothe.Currency = rates.Base
+	return nil
+}
@@ -1,11 +1,25 @@
 package main
 
 import (
+	"clothing-store/internal/i18n"
 	"fmt"
 	"net/http"
 )
 
+// errorResponse sends the message, translated into the locale of the request.
+// Validation errors are translated one by one.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+	locale := app.contextGetLocale(r)
+	switch m := message.(type) {
+	case string:
+		message = i18n.Translate(locale, m)
+	case map[string]string:
+		translated := make(map[string]string, len(m))
+		for key, value := range m {
+			translated[key] = i18n.Translate(locale, value)
+		}
+		message = translated
+	}
 	env := envelope{"error": message}
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
@@ -26,7 +40,7 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	message := fmt.Sprintf(i18n.Translate(app.contextGetLocale(r), "the %s method is not supported for this resource"), r.Method)
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
@@ -78,3 +92,23 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) impersonationNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this action is not available while impersonating a user"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notVerifiedBuyerResponse(w http.ResponseWriter, r *http.Request) {
+	message := "only customers who bought this clothe can review it"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) brandInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the brand still has clothes on sale, archive them first"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) categoryInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the category still has subcategories or clothes"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"clothing-store/internal/data"
 	"clothing-store/internal/validator"
 	"encoding/json"
 	"errors"
@@ -16,10 +17,14 @@ import (
 type envelope map[string]any
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
@@ -102,6 +107,74 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int64, v
 	return int64(i)
 }
 
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	return f
+}
+
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// readIncludeArchived reads the include_archived query parameter, which is only
+// honoured for admins.
+func (app *application) readIncludeArchived(r *http.Request, v *validator.Validator) (bool, error) {
+	return app.readAdminFlag(r, "include_archived", v)
+}
+
+// readIncludeInactive reads the include_inactive query parameter, which shows
+// collections outside their dates and is only honoured for admins.
+func (app *application) readIncludeInactive(r *http.Request, v *validator.Validator) (bool, error) {
+	return app.readAdminFlag(r, "include_inactive", v)
+}
+
+// readAdminFlag reads a boolean query parameter that is false for everyone but
+// admins.
+func (app *application) readAdminFlag(r *http.Request, key string, v *validator.Validator) (bool, error) {
+	value := app.readBool(r.URL.Query(), key, false, v)
+	if !value {
+		return false, nil
+	}
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+	roles, err := app.models.Roles.GetAllRolesForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+	return roles.Include("ADMIN"), nil
+}
+
+// audit records an action on the account of the user. actorID is the admin
+// acting on the account, 0 when the user acts themselves.
+func (app *application) audit(r *http.Request, userID, actorID int64, action string) error {
+	entry := &data.AuditEntry{
+		UserID:     userID,
+		ActorID:    actorID,
+		Action:     action,
+		RemoteAddr: r.RemoteAddr,
+	}
+	return app.models.Audit.Insert(entry)
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 	go func() {
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"clothing-store/internal/data"
+	"clothing-store/internal/thumbnail"
+	"clothing-store/internal/validator"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+func (app *application) uploadClotheImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Clothes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	upload, err := app.readImageUpload(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	image := &data.ClotheImage{
+		ClotheID:    id,
+		ContentType: upload.contentType,
+		AltText:     upload.form.Get("alt_text"),
+		Position:    app.readInt(upload.form, "position", 0, v),
+		Primary:     app.readBool(upload.form, "primary", false, v),
+	}
+	if variantID := app.readInt(upload.form, "variant_id", 0, v); variantID != 0 {
+		image.VariantID = &variantID
+	}
+	app.checkImageUpload(v, upload)
+	if data.ValidateClotheImage(v, image); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.checkImageVariant(image, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	image.StorageKey, image.URL, err = app.storeImage(r.Context(), fmt.Sprintf("clothes/%d", id), upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Images.Insert(image)
+	if err != nil {
+		app.deleteStoredImage(image.StorageKey)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.generateVariants(image.StorageKey, upload.data, func(srcset data.Srcset) error {
+		return app.models.Images.SetVariants(image.ClotheID, image.ID, srcset)
+	})
+	headers := make(http.Header)
+	headers.Set("Location", image.URL)
+	err = app.writeJSON(w, http.StatusCreated, image, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listClotheImagesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Clothes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	images, err := app.models.Images.GetAllForClothe(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateClotheImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	imageID, err := app.readNamedIDParam(r, "image_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	image, err := app.models.Images.Get(id, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// A variant_id of 0 moves the image back to the gallery of the clothe.
+	var input struct {
+		VariantID *int64  `json:"variant_id"`
+		AltText   *string `json:"alt_text"`
+		Position  *int64  `json:"position"`
+		Primary   *bool   `json:"primary"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.VariantID != nil {
+		image.VariantID = input.VariantID
+		if *input.VariantID == 0 {
+			image.VariantID = nil
+		}
+	}
+	if input.AltText != nil {
+		image.AltText = *input.AltText
+	}
+	if input.Position != nil {
+		image.Position = *input.Position
+	}
+	if input.Primary != nil {
+		// Another image has to be made primary instead.
+		v.Check(*input.Primary, "primary", "can only be set to true")
+		image.Primary = *input.Primary
+	}
+
+	if data.ValidateClotheImage(v, image); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.checkImageVariant(image, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Images.Update(image)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, image, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteClotheImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	imageID, err := app.readNamedIDParam(r, "image_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	image, err := app.models.Images.Delete(id, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.deleteStoredImage(image.StorageKey)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) uploadBrandImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	brand, err := app.models.Brands.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	upload, err := app.readImageUpload(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	_, ok := data.ImageContentTypes[upload.contentType]
+	v.Check(ok, "image", "must be a JPEG, PNG, WebP or GIF image")
+	app.checkImageUpload(v, upload)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	oldKey := brand.ImageStorageKey
+	brand.ImageStorageKey, brand.ImageURL, err = app.storeImage(r.Context(), fmt.Sprintf("brands/%d", id), upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	brand.Srcset = nil
+
+	err = app.models.Brands.Update(brand)
+	if err != nil {
+		app.deleteStoredImage(brand.ImageStorageKey)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if oldKey != "" {
+		app.deleteStoredImage(oldKey)
+	}
+	key := brand.ImageStorageKey
+	app.generateVariants(key, upload.data, func(srcset data.Srcset) error {
+		return app.models.Brands.SetImageVariants(id, key, srcset)
+	})
+
+	err = app.writeJSON(w, http.StatusOK, brand, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkImageVariant checks that the image is attached to a variant of its own
+// clothe.
+func (app *application) checkImageVariant(image *data.ClotheImage, v *validator.Validator) error {
+	if image.VariantID == nil {
+		return nil
+	}
+	_, err := app.models.Variants.Get(image.ClotheID, *image.VariantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("variant_id", "must refer to a variant of the clothe")
+			return nil
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+type imageUpload struct {
+	data        []byte
+	contentType string
+	form        url.Values
+}
+
+// readImageUpload reads the "image" file and the other fields of a multipart
+// form. The content type sent by the client is not trusted, it is sniffed
+// from the file instead. Files over data.MaxImageSize are cut one byte past
+// the limit, which the caller is expected to reject.
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request) (*imageUpload, error) {
+	// Leave some room for the other form fields and the multipart framing.
+	r.Body = http.MaxBytesReader(w, r.Body, data.MaxImageSize+1<<20)
+	err := r.ParseMultipartForm(data.MaxImageSize)
+	if err != nil {
+		return nil, fmt.Errorf("body must be a multipart form of at most %d bytes", data.MaxImageSize)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return nil, errors.New("body must contain an image file")
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, data.MaxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	return &imageUpload{
+		data:        content,
+		contentType: http.DetectContentType(content),
+		form:        r.PostForm,
+	}, nil
+}
+
+// checkImageUpload checks the file size of the upload and, reading only its
+// header, its dimensions.
+func (app *application) checkImageUpload(v *validator.Validator, upload *imageUpload) {
+	v.Check(len(upload.data) <= data.MaxImageSize, "image", "must not be more than 5 MB")
+	err := thumbnail.CheckSize(upload.data)
+	v.Check(!errors.Is(err, thumbnail.ErrTooLarge), "image", "must not be more than 10000 pixels wide or high, or 40 megapixels in total")
+}
+
+// storeImage saves the upload under a random name below prefix and returns
+// its storage key and URL.
+func (app *application) storeImage(ctx context.Context, prefix string, upload *imageUpload) (string, string, error) {
+	name := make([]byte, 16)
+	_, err := rand.Read(name)
+	if err != nil {
+		return "", "", err
+	}
+	key := fmt.Sprintf("%s/%s%s", prefix, hex.EncodeToString(name), data.ImageContentTypes[upload.contentType])
+	location, err := app.storage.Put(ctx, key, bytes.NewReader(upload.data), upload.contentType)
+	if err != nil {
+		return "", "", err
+	}
+	return key, location, nil
+}
+
+// generateVariants resizes the image stored under key in the background and
+// hands the URLs of the variants to save. When save reports that the image is
+// gone by now the variants are deleted again.
+func (app *application) generateVariants(key string, original []byte, save func(data.Srcset) error) {
+	app.background(func() {
+		variants, err := thumbnail.Generate(original)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"storage_key": key})
+			return
+		}
+		srcset := data.Srcset{}
+		for _, variant := range variants {
+			location, err := app.storage.Put(context.Background(), thumbnail.Key(key, variant.Width, variant.Format),
+				bytes.NewReader(variant.Data), variant.Format.ContentType())
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"storage_key": key})
+				return
+			}
+			srcset[variant.SrcsetKey()] = location
+		}
+		err = save(srcset)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.deleteStoredImage(key)
+				return
+			}
+			app.logger.PrintError(err, map[string]string{"storage_key": key})
+		}
+	})
+}
+
+// deleteStoredImage removes an image that is no longer referenced together
+// with its variants. Failures only leave orphaned files behind, so they are
+// logged instead of reported.
+func (app *application) deleteStoredImage(key string) {
+	app.background(func() {
+		for _, key := range append([]string{key}, thumbnail.Keys(key)...) {
+			err := app.storage.Delete(context.Background(), key)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"storage_key": key})
+			}
+		}
+	})
+}
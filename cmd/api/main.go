@@ -4,6 +4,7 @@ import (
 	"clothing-store/internal/data"
 	"clothing-store/internal/jsonlog"
 	"clothing-store/internal/mailer"
+	"clothing-store/internal/storage"
 	"context"      // New import
 	"database/sql" // New import
 	"flag"
@@ -36,13 +37,29 @@ type config struct {
 		password string
 		sender   string
 	}
+	storage struct {
+		dir         string
+		baseURL     string
+		s3Endpoint  string
+		s3Region    string
+		s3Bucket    string
+		s3AccessKey string
+		s3SecretKey string
+		s3PublicURL string
+	}
+	relatedRefreshInterval time.Duration
 }
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	alerts  alertQueue
+	// shutdown is closed once the server shuts down, to stop the periodic
+	// background tasks.
+	shutdown chan struct{}
+	wg       sync.WaitGroup
 }
 
 func main() {
@@ -65,6 +82,17 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "ec70d281de0e41", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "noreply@clotheshop.com", "SMTP sender")
 
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory for uploaded files, served at the path of storage-base-url")
+	flag.StringVar(&cfg.storage.baseURL, "storage-base-url", "/static", "URL the storage directory is served at")
+	flag.StringVar(&cfg.storage.s3Endpoint, "s3-endpoint", "", "S3 compatible endpoint, uploads are stored locally when empty")
+	flag.StringVar(&cfg.storage.s3Region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3Bucket, "s3-bucket", "", "S3 bucket")
+	flag.StringVar(&cfg.storage.s3AccessKey, "s3-access-key", "", "S3 access key")
+	flag.StringVar(&cfg.storage.s3SecretKey, "s3-secret-key", "", "S3 secret key")
+	flag.StringVar(&cfg.storage.s3PublicURL, "s3-public-url", "", "Public URL of the S3 bucket")
+
+	flag.DurationVar(&cfg.relatedRefreshInterval, "related-refresh-interval", time.Hour, "How often related clothes are recomputed")
+
 	flag.Parse()
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 	db, err := openDB(cfg)
@@ -75,18 +103,30 @@ func main() {
 	logger.PrintInfo("database connection pool established", nil)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage:  newStorage(cfg),
+		shutdown: make(chan struct{}),
 	}
 
+	app.refreshRelatedClothes(cfg.relatedRefreshInterval)
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
 }
 
+func newStorage(cfg config) storage.Storage {
+	if cfg.storage.s3Endpoint != "" {
+		return storage.NewS3(cfg.storage.s3Endpoint, cfg.storage.s3Region, cfg.storage.s3Bucket,
+			cfg.storage.s3AccessKey, cfg.storage.s3SecretKey, cfg.storage.s3PublicURL)
+	}
+	return storage.NewLocal(cfg.storage.dir, cfg.storage.baseURL)
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -3,19 +3,37 @@ package main
 import (
 	"bytes"
 	"clothing-store/internal/data"
+	"clothing-store/internal/i18n"
 	"clothing-store/internal/jsonlog"
 	"clothing-store/internal/mailer"
+	"clothing-store/internal/storage"
+	"clothing-store/internal/thumbnail"
 	"clothing-store/internal/validator"
 	"context"
-	_ "database/sql"
+	"database/sql"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
 	_ "log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 var testApp application
@@ -41,6 +59,9 @@ func GetApp() application {
 	cfg.smtp.password = "ec70d281de0e41"
 	cfg.smtp.sender = "noreply@clotheshop.com"
 
+	cfg.storage.dir = os.TempDir()
+	cfg.storage.baseURL = "/static"
+
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 	db, err := openDB(cfg)
 	if err != nil {
@@ -50,10 +71,11 @@ func GetApp() application {
 	logger.PrintInfo("database connection pool established", nil)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: newStorage(cfg),
 	}
 
 	//err = app.serve()
@@ -127,6 +149,54 @@ func TestValidateBrand(t *testing.T) {
 
 }
 
+func TestValidateClothe(t *testing.T) {
+	clothe := &data.Clothe{
+		Name:       "Hoodie",
+		Price:      20000,
+		BrandID:    1,
+		Color:      "black",
+		Sizes:      []string{"S", "M"},
+		Sex:        "men",
+		CategoryID: 2,
+		ImageURL:   "img.jpeg",
+	}
+
+	alpha := &data.SizeSystem{Slug: "alpha", Sizes: []string{"XS", "S", "M", "L", "XL", "XXL"}}
+
+	v := validator.New()
+	if data.ValidateClothe(v, clothe, alpha); !v.Valid() {
+		t.Errorf("%v", v.Errors)
+	}
+
+	clothe.CategoryID = 0
+	clothe.Sex = "male"
+
+	v1 := validator.New()
+	data.ValidateClothe(v1, clothe, alpha)
+	if _, ok := v1.Errors["category_id"]; !ok {
+		t.Errorf("Expected an error for a missing category, got %v", v1.Errors)
+	}
+	if _, ok := v1.Errors["sex"]; !ok {
+		t.Errorf("Expected an error for invalid sex, got %v", v1.Errors)
+	}
+
+	clothe.CategoryID = 2
+	clothe.Sex = "men"
+	clothe.Sizes = []string{"M", "42"}
+
+	v2 := validator.New()
+	data.ValidateClothe(v2, clothe, alpha)
+	if _, ok := v2.Errors["sizes"]; !ok {
+		t.Errorf("Expected an error for a size outside the size system, got %v", v2.Errors)
+	}
+
+	v3 := validator.New()
+	data.ValidateClothe(v3, clothe, nil)
+	if _, ok := v3.Errors["size_system_id"]; !ok {
+		t.Errorf("Expected an error for an unknown size system, got %v", v3.Errors)
+	}
+}
+
 func TestValidatePermittedValue(t *testing.T) {
 	value := "s"
 	value1 := "small"
@@ -232,8 +302,12 @@ func TestGetAllClothes(t *testing.T) {
 			status, http.StatusOK)
 	}
 
-	var arr []data.Clothe
-	var arr1 []data.Clothe
+	type listResponse struct {
+		Clothes  []data.Clothe `json:"clothes"`
+		Metadata data.Metadata `json:"metadata"`
+	}
+	var arr listResponse
+	var arr1 listResponse
 
 	response := rr.Body.String()
 
@@ -242,15 +316,28 @@ func TestGetAllClothes(t *testing.T) {
 		t.Errorf("Can't marshall response to clothe type")
 	}
 
+	brand := &data.Brand{
+		Name:        "test",
+		Country:     "test",
+		Description: "test",
+		ImageURL:    "test",
+	}
+	testApp.models.Brands.Insert(brand)
+
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	clothe := &data.Clothe{
-		Name:     "test",
-		Price:    1,
-		Brand:    "test",
-		Color:    "test",
-		Sizes:    []string{},
-		Sex:      "",
-		Type:     "",
-		ImageURL: "",
+		Name:       "test",
+		Price:      1,
+		BrandID:    brand.ID,
+		Color:      "test",
+		Sizes:      []string{},
+		Sex:        "",
+		CategoryID: category.ID,
+		ImageURL:   "",
 	}
 
 	testApp.models.Clothes.Insert(clothe)
@@ -269,8 +356,8 @@ func TestGetAllClothes(t *testing.T) {
 		t.Errorf("Can't marshall response to clothe type")
 	}
 
-	if len(arr1)-len(arr) != 1 {
-		t.Errorf("Data length before insertion %v, after %v", len(arr), len(arr1))
+	if arr1.Metadata.TotalRecords-arr.Metadata.TotalRecords != 1 {
+		t.Errorf("Data length before insertion %v, after %v", arr.Metadata.TotalRecords, arr1.Metadata.TotalRecords)
 	}
 }
 
@@ -331,3 +418,1613 @@ func TestRequireRole(t *testing.T) {
 		t.Errorf("Expected to get status code 200, but got %v", w1.Code)
 	}
 }
+
+func TestForbidImpersonation(t *testing.T) {
+	handler := testApp.forbidImpersonation(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	user := &data.User{ID: 2, Activated: true}
+	admin := &data.User{ID: 1, Activated: true}
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/buy/1", nil)
+	req = testApp.contextSetUser(req, user)
+	req = testApp.contextSetRealUser(req, user)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected to get status code 200, but got %v", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/v1/buy/1", nil)
+	req = testApp.contextSetUser(req, user)
+	req = testApp.contextSetRealUser(req, admin)
+	w1 := httptest.NewRecorder()
+	handler.ServeHTTP(w1, req)
+
+	if w1.Code != http.StatusForbidden {
+		t.Errorf("Expected to get status code 403, but got %v", w1.Code)
+	}
+}
+
+func TestValidateFiltersCursor(t *testing.T) {
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     20,
+		Sort:         "price",
+		SortSafelist: []string{"id", "price"},
+		Cursor:       "not a cursor",
+	}
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); v.Valid() {
+		t.Errorf("Expected an error for an invalid cursor")
+	}
+
+	// {"s":"id","v":"5","id":5}, created for another sort.
+	filters.Cursor = "eyJzIjoiaWQiLCJ2IjoiNSIsImlkIjo1fQ"
+
+	v1 := validator.New()
+	if data.ValidateFilters(v1, filters); v1.Valid() {
+		t.Errorf("Expected an error for a cursor of another sort")
+	}
+
+	filters.Sort = "id"
+
+	v2 := validator.New()
+	if data.ValidateFilters(v2, filters); !v2.Valid() {
+		t.Errorf("%v", v2.Errors)
+	}
+}
+
+func TestGetAllClothesFilters(t *testing.T) {
+	brand := &data.Brand{
+		Name:        "filters",
+		Country:     "test",
+		Description: "test",
+		ImageURL:    "test",
+	}
+	// Every run inserts a brand of its own, which keeps other rows of the
+	// test database out.
+	err := testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clothes := []*data.Clothe{
+		{Name: "Hooded jacket", Price: 100, Color: "red", Sizes: []string{"S"}, Sex: "men"},
+		{Name: "Denim jeans", Price: 200, Color: "blue", Sizes: []string{"M", "L"}, Sex: "women"},
+		{Name: "Wool sweater", Price: 300, Color: "Red", Sizes: []string{"L"}, Sex: "women"},
+	}
+	for _, clothe := range clothes {
+		clothe.BrandID = brand.ID
+		clothe.CategoryID = category.ID
+		clothe.ImageURL = "test"
+		err := testApp.models.Clothes.Insert(clothe)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	price := int64(50)
+	err = testApp.models.Variants.Insert(&data.ClotheVariant{
+		ClotheID: clothes[0].ID,
+		Color:    "green",
+		Sizes:    []string{"XL"},
+		Price:    &price,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	tests := []struct {
+		query data.ClotheQuery
+		want  int
+	}{
+		{data.ClotheQuery{PriceMin: 100, PriceMax: 200}, 2},
+		{data.ClotheQuery{PriceMin: 200, PriceMax: 200}, 1},
+		{data.ClotheQuery{PriceMin: 101, PriceMax: 299}, 1},
+		{data.ClotheQuery{PriceMin: 200}, 2},
+		{data.ClotheQuery{Colors: []string{"RED"}}, 2},
+		{data.ClotheQuery{Colors: []string{"red", "blue"}}, 3},
+		{data.ClotheQuery{Sex: "women"}, 2},
+		{data.ClotheQuery{Sizes: []string{"m", "l"}, SizesMatch: "all"}, 1},
+		{data.ClotheQuery{Sizes: []string{"m", "l"}, SizesMatch: "any"}, 2},
+		{data.ClotheQuery{Brands: []string{"nobody", "FILTERS"}}, 3},
+		{data.ClotheQuery{Colors: []string{"Green"}}, 1},
+		{data.ClotheQuery{Colors: []string{"green"}, Sizes: []string{"s"}}, 0},
+		{data.ClotheQuery{PriceMax: 60}, 1},
+		{data.ClotheQuery{Search: "hood"}, 1},
+		{data.ClotheQuery{Search: "sweatr"}, 1},
+		{data.ClotheQuery{Search: "filtres"}, 3},
+	}
+	for _, tt := range tests {
+		tt.query.BrandID = brand.ID
+		got, _, err := testApp.models.Clothes.GetAll(tt.query, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("%+v: expected %d clothes, got %d", tt.query, tt.want, len(got))
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	brand := &data.Brand{
+		Name:        "Suggestible",
+		Country:     "test",
+		Description: "test",
+		ImageURL:    "test",
+	}
+	err := testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"sugges", "sugestible"} {
+		suggestions, err := testApp.models.Search.Suggest(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(suggestions.Brands) > data.SuggestionsPerGroup {
+			t.Errorf("%s: expected at most %d brands, got %d", q, data.SuggestionsPerGroup, len(suggestions.Brands))
+		}
+		found := false
+		for _, suggestion := range suggestions.Brands {
+			// Earlier runs leave brands of the same name behind.
+			if suggestion.Text == brand.Name {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected brand %s among %+v", q, brand.Name, suggestions.Brands)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	s := storage.NewLocal(t.TempDir(), "/static/")
+
+	url, err := s.Put(context.Background(), "clothes/1/a.png", strings.NewReader("png"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "/static/clothes/1/a.png" {
+		t.Errorf("Unexpected url %s", url)
+	}
+	err = s.Delete(context.Background(), "clothes/1/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "/etc/passwd", "../a.png", "clothes/../../a.png"} {
+		_, err := s.Put(context.Background(), key, strings.NewReader("png"), "image/png")
+		if !errors.Is(err, storage.ErrInvalidKey) {
+			t.Errorf("%q: expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+}
+
+func TestValidateClotheImage(t *testing.T) {
+	image := &data.ClotheImage{ContentType: "image/png", AltText: "front"}
+
+	v := validator.New()
+	if data.ValidateClotheImage(v, image); !v.Valid() {
+		t.Errorf("%v", v.Errors)
+	}
+
+	image.ContentType = "text/html; charset=utf-8"
+	image.Position = -1
+
+	v1 := validator.New()
+	data.ValidateClotheImage(v1, image)
+	if _, ok := v1.Errors["image"]; !ok {
+		t.Errorf("Expected an error for the content type, got %v", v1.Errors)
+	}
+	if _, ok := v1.Errors["position"]; !ok {
+		t.Errorf("Expected an error for the position, got %v", v1.Errors)
+	}
+}
+
+func TestGenerateThumbnails(t *testing.T) {
+	var original bytes.Buffer
+	err := png.Encode(&original, image.NewRGBA(image.Rect(0, 0, 800, 400)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variants, err := thumbnail.Generate(original.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The image is narrower than the large variant, which is skipped.
+	if len(variants) != 4 {
+		t.Fatalf("Expected 4 variants, got %d", len(variants))
+	}
+	for _, variant := range variants {
+		config, format, err := image.DecodeConfig(bytes.NewReader(variant.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != string(variant.Format) {
+			t.Errorf("%s: expected %s, got %s", variant.SrcsetKey(), variant.Format, format)
+		}
+		if config.Width != variant.Width || config.Height != variant.Width/2 {
+			t.Errorf("%s: unexpected size %dx%d", variant.Descriptor(), config.Width, config.Height)
+		}
+	}
+
+	if key := thumbnail.Key("clothes/1/a.png", 160, thumbnail.JPEG); key != "clothes/1/a-160w.jpg" {
+		t.Errorf("Unexpected key %s", key)
+	}
+	if key := thumbnail.Key("clothes/1/a.png", 160, thumbnail.WebP); key != "clothes/1/a-160w.webp" {
+		t.Errorf("Unexpected key %s", key)
+	}
+
+	// A tiny file claiming huge dimensions is rejected before it is decoded.
+	var huge bytes.Buffer
+	err = gif.Encode(&huge, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := huge.Bytes()
+	// The logical screen width and height follow the GIF signature.
+	binary.LittleEndian.PutUint16(header[6:], 20000)
+	binary.LittleEndian.PutUint16(header[8:], 20000)
+	_, err = thumbnail.Generate(header)
+	if !errors.Is(err, thumbnail.ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestValidateClotheVariant(t *testing.T) {
+	price := int64(0)
+	variant := &data.ClotheVariant{Color: "Black", Sizes: []string{"S", "S"}, Price: &price}
+	alpha := &data.SizeSystem{Slug: "alpha", Sizes: []string{"S", "M", "L"}}
+
+	v := validator.New()
+	data.ValidateClotheVariant(v, variant, "black", alpha)
+	for _, key := range []string{"color", "sizes", "price"} {
+		if _, ok := v.Errors[key]; !ok {
+			t.Errorf("Expected an error for %s, got %v", key, v.Errors)
+		}
+	}
+
+	variant.Color = "white"
+	variant.Sizes = []string{"S", "M"}
+	variant.Price = nil
+
+	v1 := validator.New()
+	if data.ValidateClotheVariant(v1, variant, "black", alpha); !v1.Valid() {
+		t.Errorf("%v", v1.Errors)
+	}
+}
+
+func TestValidateReview(t *testing.T) {
+	fit := "too_tight"
+	review := &data.Review{Rating: 6, Title: "", Body: "Nice", Fit: &fit}
+
+	v := validator.New()
+	data.ValidateReview(v, review)
+	for _, key := range []string{"rating", "title", "fit"} {
+		if _, ok := v.Errors[key]; !ok {
+			t.Errorf("Expected an error for %s, got %v", key, v.Errors)
+		}
+	}
+
+	fit = "runs_small"
+	review.Rating = 4
+	review.Title = "Good"
+
+	v1 := validator.New()
+	if data.ValidateReview(v1, review); !v1.Valid() {
+		t.Errorf("%v", v1.Errors)
+	}
+
+	v2 := validator.New()
+	data.ValidateKeys(v2, data.Keys{SizesMatch: "all", RatingMin: 5.5})
+	if _, ok := v2.Errors["rating_min"]; !ok {
+		t.Errorf("Expected an error for rating_min, got %v", v2.Errors)
+	}
+}
+
+func TestRemoveFromWishlistRequiresMe(t *testing.T) {
+	req := httptest.NewRequest(http.MethodDelete, "/v1/users/5/wishlist/1", nil)
+	params := httprouter.Params{{Key: "id", Value: "5"}, {Key: "clothe_id", Value: "1"}}
+	req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+	req = testApp.contextSetUser(req, &data.User{ID: 1, Activated: true})
+
+	rr := httptest.NewRecorder()
+	testApp.removeFromWishlistHandler(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestValidateAlert(t *testing.T) {
+	tests := []struct {
+		alert data.Alert
+		field string
+	}{
+		{data.Alert{Kind: "restock", Size: "M"}, "kind"},
+		{data.Alert{Kind: data.AlertBackInStock}, "size"},
+		{data.Alert{Kind: data.AlertPriceDrop, Size: "M"}, "size"},
+		{data.Alert{Kind: data.AlertBackInStock, Size: "XXXXL"}, "size"},
+		{data.Alert{Kind: data.AlertBackInStock, Size: "M"}, ""},
+		{data.Alert{Kind: data.AlertBackInStock, Size: "m"}, ""},
+		{data.Alert{Kind: data.AlertPriceDrop}, ""},
+	}
+	system := &data.SizeSystem{Slug: "alpha", Sizes: []string{"S", "M", "L"}}
+	for _, tt := range tests {
+		v := validator.New()
+		data.ValidateAlert(v, &tt.alert, system)
+		if tt.field == "" && !v.Valid() {
+			t.Errorf("%+v: %v", tt.alert, v.Errors)
+		}
+		if _, ok := v.Errors[tt.field]; tt.field != "" && !ok {
+			t.Errorf("%+v: expected an error for %s, got %v", tt.alert, tt.field, v.Errors)
+		}
+	}
+}
+
+func TestRecommendSize(t *testing.T) {
+	runsSmall := "runs_small"
+	alpha := &data.SizeSystem{Slug: "alpha", Sizes: []string{"XXS", "XS", "S", "M", "L", "XL", "XXL", "XXXL"}}
+	shoes := &data.SizeSystem{Slug: "eu-shoe", Sizes: []string{"38", "39", "40", "41", "42", "43"}}
+	chart := []data.SizeChartRow{
+		{Size: "S", Measurements: data.Measurements{"chest": 90, "waist": 76}},
+		{Size: "M", Measurements: data.Measurements{"chest": 98, "waist": 84}},
+		{Size: "L", Measurements: data.Measurements{"chest": 106, "waist": 92}},
+	}
+	tests := []struct {
+		name      string
+		sizes     []string
+		system    *data.SizeSystem
+		history   []data.SizePurchase
+		clotheFit data.FitCounts
+		popular   string
+		want      string
+	}{
+		{"middle size", []string{"L", "S", "M"}, alpha, nil, data.FitCounts{}, "", "M"},
+		{"popular size", []string{"S", "M", "L"}, alpha, nil, data.FitCounts{}, "L", "L"},
+		{"history", []string{"S", "M", "L", "XL"}, alpha, []data.SizePurchase{
+			{Size: "L", SameBrand: true, SameCategory: true},
+			{Size: "M", SameBrand: true, SameCategory: true},
+			{Size: "L", SameBrand: true, SameCategory: true},
+			{Size: "S", SameBrand: true},
+		}, data.FitCounts{}, "S", "L"},
+		{"own fit", []string{"S", "M", "L"}, alpha, []data.SizePurchase{{Size: "M", SameCategory: true, Fit: &runsSmall}}, data.FitCounts{}, "", "L"},
+		{"clothe fit", []string{"S", "M", "L"}, alpha, nil, data.FitCounts{RunsSmall: 3, TrueToSize: 1}, "M", "L"},
+		{"nearest size", []string{"S", "M", "L"}, alpha, []data.SizePurchase{{Size: "2XL", SameBrand: true}}, data.FitCounts{}, "", "L"},
+		{"numeric sizes", []string{"42", "38", "40"}, shoes, []data.SizePurchase{{Size: "41", SameBrand: true, SameCategory: true}}, data.FitCounts{}, "", "42"},
+		{"size chart", []string{"S", "M", "L"}, alpha, []data.SizePurchase{
+			{Size: "XL", Measurements: data.Measurements{"chest": 100, "waist": 86, "hips": 104}},
+			{Size: "S", Measurements: data.Measurements{"chest": 90}},
+		}, data.FitCounts{}, "S", "M"},
+		{"size chart without shared measurements", []string{"S", "M", "L"}, alpha, []data.SizePurchase{
+			{Size: "XL", Measurements: data.Measurements{"inseam": 80}},
+		}, data.FitCounts{}, "L", "L"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clothe := &data.Clothe{Sizes: tt.sizes}
+			got := data.RecommendSize(clothe, tt.system, tt.history, chart, tt.clotheFit, data.FitCounts{}, tt.popular)
+			if got.Size != tt.want {
+				t.Errorf("Expected %s, got %+v", tt.want, got)
+			}
+			if got.Confidence <= 0 || got.Confidence > 1 || got.Explanation == "" {
+				t.Errorf("Unexpected recommendation %+v", got)
+			}
+		})
+	}
+}
+
+func TestValidateSizeChart(t *testing.T) {
+	shoes := &data.SizeSystem{Slug: "eu-shoe", Sizes: []string{"40", "41", "42"}}
+
+	v := validator.New()
+	rows := []data.SizeChartRow{{Size: "41", Measurements: data.Measurements{"foot_length": 26.5}}}
+	if data.ValidateSizeChart(v, rows, shoes); !v.Valid() {
+		t.Errorf("%v", v.Errors)
+	}
+
+	for _, rows := range [][]data.SizeChartRow{
+		{{Size: "M", Measurements: data.Measurements{"foot_length": 26.5}}},
+		{{Size: "41", Measurements: data.Measurements{"elbow": 30}}},
+		{{Size: "41", Measurements: data.Measurements{"foot_length": -1}}},
+		{{Size: "41"}},
+		{{Size: "41", Measurements: data.Measurements{"foot_length": 26.5}}, {Size: "41", Measurements: data.Measurements{"foot_length": 27}}},
+	} {
+		v := validator.New()
+		if data.ValidateSizeChart(v, rows, shoes); v.Valid() {
+			t.Errorf("Expected an error for %+v", rows)
+		}
+	}
+}
+
+func TestConvertCurrency(t *testing.T) {
+	rates, err := data.NewRates([]*data.ExchangeRate{
+		{Currency: "USD", Rate: "1", MinorUnits: 2, Base: true},
+		{Currency: "EUR", Rate: "0.9", MinorUnits: 2},
+		{Currency: "JPY", Rate: "150", MinorUnits: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		amount   int64
+		from, to string
+		want     int64
+	}{
+		{1999, "USD", "USD", 1999},
+		{1000, "USD", "EUR", 900},
+		{900, "EUR", "USD", 1000},
+		// 19.99 * 150 = 2998.5 JPY, which has no minor units
+		{1999, "USD", "JPY", 2998},
+		// 0.05 * 0.9 = 0.045 EUR, half to even rounds 4.5 cents down
+		{5, "USD", "EUR", 4},
+		// 0.15 * 0.9 = 0.135 EUR, half to even rounds 13.5 cents up
+		{15, "USD", "EUR", 14},
+		{-15, "USD", "EUR", -14},
+	}
+	for _, tt := range tests {
+		got, err := rates.Convert(tt.amount, tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Convert(%d, %s, %s): expected %d, got %d", tt.amount, tt.from, tt.to, tt.want, got)
+		}
+	}
+	if got := rates.Format(1999, "USD"); got != "19.99 USD" {
+		t.Errorf("Expected 19.99 USD, got %s", got)
+	}
+	if got := rates.Format(2998, "JPY"); got != "2998 JPY" {
+		t.Errorf("Expected 2998 JPY, got %s", got)
+	}
+	if got := rates.Amount(5, "USD"); got != 500 {
+		t.Errorf("Expected 500, got %d", got)
+	}
+	if got := rates.Amount(5, "JPY"); got != 5 {
+		t.Errorf("Expected 5, got %d", got)
+	}
+	if _, err := rates.Convert(100, "USD", "GBP"); !errors.Is(err, data.ErrUnsupportedCurrency) {
+		t.Errorf("Expected ErrUnsupportedCurrency, got %v", err)
+	}
+
+	stats := &data.BrandStats{PriceMin: 1000, PriceMax: 1999}
+	err = testApp.convertBrandStats(stats, rates, "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PriceMin != 1500 || stats.PriceMax != 2998 || stats.Currency != "JPY" {
+		t.Errorf("Expected the price range in JPY, got %+v", stats)
+	}
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"ru", "ru"},
+		{"ru-KZ,ru;q=0.9,en;q=0.8", "ru"},
+		{"en;q=0.5, kk-KZ", "kk"},
+		{"de, fr;q=0.9", "en"},
+		{"kk;q=0, ru;q=0.3", "ru"},
+	}
+	for _, tt := range tests {
+		if got := i18n.Negotiate(tt.header); got != tt.want {
+			t.Errorf("Negotiate(%q): expected %s, got %s", tt.header, tt.want, got)
+		}
+	}
+	if got := strings.Join(i18n.Chain("kk"), ","); got != "kk,ru,en" {
+		t.Errorf("Expected kk,ru,en, got %s", got)
+	}
+	if got := i18n.Translate("kk", "must be provided"); got != "міндетті өріс" {
+		t.Errorf("Expected the Kazakh message, got %s", got)
+	}
+	// Every message is in every catalogue, the fallback is checked on stub
+	// catalogues.
+	stub := i18n.Catalogues{
+		"ru": {"invalid cursor": "недопустимый курсор"},
+		"kk": {},
+	}
+	if got := stub.Translate("kk", "invalid cursor"); got != "недопустимый курсор" {
+		t.Errorf("Expected the Russian message, got %s", got)
+	}
+	if got := stub.Translate("kk", "must be provided"); got != "must be provided" {
+		t.Errorf("Expected the English message, got %s", got)
+	}
+	if got := i18n.Translate("ru", "no such message"); got != "no such message" {
+		t.Errorf("Expected the message unchanged, got %s", got)
+	}
+}
+
+func TestValidateBundlePurchase(t *testing.T) {
+	bundle := &data.Bundle{
+		Clothes: []*data.Clothe{
+			{ID: 1, Sizes: []string{"S", "m"}, Variants: []data.ClotheVariant{{ID: 5, Sizes: []string{"XL"}}}},
+			{ID: 2, Sizes: []string{"42", "43"}},
+		},
+	}
+	tests := []struct {
+		sizes    map[int64]string
+		variants map[int64]int64
+		valid    bool
+	}{
+		{map[int64]string{1: "M", 2: "42"}, nil, true},
+		// XL is only sold in variant 5.
+		{map[int64]string{1: "XL", 2: "43"}, map[int64]int64{1: 5}, true},
+		{map[int64]string{1: "XL", 2: "43"}, nil, false},
+		{map[int64]string{1: "M", 2: "43"}, map[int64]int64{1: 5}, false},
+		{map[int64]string{1: "M", 2: "42"}, map[int64]int64{1: 6}, false},
+		{map[int64]string{1: "M", 2: "42"}, map[int64]int64{3: 5}, false},
+		{map[int64]string{1: "M"}, nil, false},
+		{map[int64]string{1: "L", 2: "42"}, nil, false},
+		{map[int64]string{1: "M", 2: "42", 3: "S"}, nil, false},
+		{nil, nil, false},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		data.ValidateBundlePurchase(v, bundle, tt.sizes, tt.variants)
+		if v.Valid() != tt.valid {
+			t.Errorf("%v %v: expected valid to be %t, got %v", tt.sizes, tt.variants, tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestCollectionActive(t *testing.T) {
+	start := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		collection data.Collection
+		now        time.Time
+		active     bool
+	}{
+		{data.Collection{}, start, true},
+		{data.Collection{StartsAt: &start, EndsAt: &end}, start, true},
+		{data.Collection{StartsAt: &start, EndsAt: &end}, start.Add(-time.Second), false},
+		{data.Collection{StartsAt: &start, EndsAt: &end}, end, false},
+		{data.Collection{StartsAt: &start}, end.AddDate(1, 0, 0), true},
+		{data.Collection{EndsAt: &end}, start.AddDate(-1, 0, 0), true},
+	}
+	for _, tt := range tests {
+		if got := tt.collection.Active(tt.now); got != tt.active {
+			t.Errorf("%v-%v at %v: expected active to be %t", tt.collection.StartsAt, tt.collection.EndsAt, tt.now, tt.active)
+		}
+	}
+
+	v := validator.New()
+	data.ValidateCollection(v, &data.Collection{Name: "Summer 2026", Slug: "summer-2026", StartsAt: &end, EndsAt: &start})
+	if _, ok := v.Errors["ends_at"]; !ok || len(v.Errors) != 1 {
+		t.Errorf("expected only an ends_at error, got %v", v.Errors)
+	}
+}
+
+func TestShowInactiveCollection(t *testing.T) {
+	end := time.Now().AddDate(0, 0, -1)
+	collection := &data.Collection{Slug: "expired-collection", Name: "Expired", EndsAt: &end}
+	err := testApp.models.Collections.Insert(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	show := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/collections/"+collection.Slug+"?"+query, nil)
+		params := httprouter.Params{{Key: "slug", Value: collection.Slug}}
+		req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+		req = testApp.contextSetUser(req, data.AnonymousUser)
+		rr := httptest.NewRecorder()
+		testApp.showCollectionHandler(rr, req)
+		return rr
+	}
+
+	if rr := show("include_inactive=maybe"); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d for an invalid flag, got %d %s", http.StatusUnprocessableEntity, rr.Code, rr.Body.String())
+	}
+	// include_archived only concerns archived clothes.
+	if rr := show("include_archived=true"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d %s", http.StatusNotFound, rr.Code, rr.Body.String())
+	}
+	// include_inactive is only honoured for admins.
+	if rr := show("include_inactive=true"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an anonymous user, got %d %s", http.StatusNotFound, rr.Code, rr.Body.String())
+	}
+}
+
+func TestExportUser(t *testing.T) {
+	newUser := func(name string) *data.User {
+		user := &data.User{
+			Name:      name,
+			Email:     fmt.Sprintf("%s-%d@example.com", name, time.Now().UnixNano()),
+			Activated: true,
+		}
+		user.Password.Set("test22222222222!")
+		err := testApp.models.Users.Insert(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	user := newUser("export")
+	admin := newUser("support")
+
+	token, err := testApp.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impersonation, err := testApp.models.Tokens.NewImpersonation(user.ID, admin.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{token.Plaintext, http.StatusOK},
+		{impersonation.Plaintext, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/users/me/export", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		testApp.routes().ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Fatalf("expected status code %d, got %d", tt.want, w.Code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("expected the export to be sent as an attachment")
+		}
+		var export struct {
+			Profile      data.User         `json:"profile"`
+			AuditEntries []data.AuditEntry `json:"audit_entries"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &export)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if export.Profile.Email != user.Email {
+			t.Errorf("expected the profile of %s, got %s", user.Email, export.Profile.Email)
+		}
+		if n := len(export.AuditEntries); n == 0 || export.AuditEntries[n-1].Action != data.AuditExport {
+			t.Errorf("expected the export to be audited, got %v", export.AuditEntries)
+		}
+		var sections map[string]json.RawMessage
+		err = json.Unmarshal(w.Body.Bytes(), &sections)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"cart", "reviews", "wishlist", "alerts", "tokens", "audit_entries"} {
+			if _, ok := sections[key]; !ok {
+				t.Errorf("expected the export to hold %s", key)
+			}
+		}
+	}
+}
+
+func TestBrandFilters(t *testing.T) {
+	// The suffix keeps brands of earlier runs out of the results.
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	brands := []*data.Brand{
+		{Name: "100% cotton " + suffix, Country: "Kazakhstan"},
+		{Name: "1000 cotton " + suffix, Country: "Italy"},
+		{Name: "snake_case " + suffix, Country: "Italy"},
+	}
+	for _, brand := range brands {
+		brand.Description = "test"
+		brand.ImageURL = "test"
+		err := testApp.models.Brands.Insert(brand)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clothe *data.Clothe
+	for _, price := range []int64{300, 100} {
+		clothe = &data.Clothe{
+			Name:       "Stats",
+			Price:      price,
+			BrandID:    brands[0].ID,
+			Color:      "red",
+			Sizes:      []string{"M"},
+			Sex:        "unisex",
+			CategoryID: category.ID,
+		}
+		err := testApp.models.Clothes.Insert(clothe)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The variant overrides the price and adds a color and a size.
+	price := int64(500)
+	err = testApp.models.Variants.Insert(&data.ClotheVariant{ClotheID: clothe.ID, Color: "blue", Sizes: []string{"XL"}, Price: &price})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+	tests := []struct {
+		name    string
+		country string
+		want    int
+	}{
+		{"0% cotton " + suffix, "", 1},
+		{"00 cotton " + suffix, "", 1},
+		{"e_c", "", 1},
+		{"_", "", 1},
+		{"cotton " + suffix, "italy", 1},
+	}
+	for _, tt := range tests {
+		got, _, err := testApp.models.Brands.GetAll(tt.name, tt.country, false, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matching := 0
+		for _, brand := range got {
+			if strings.HasSuffix(brand.Name, suffix) {
+				matching++
+			}
+		}
+		if matching != tt.want {
+			t.Errorf("%q in %q: expected %d brands, got %d", tt.name, tt.country, tt.want, matching)
+		}
+	}
+
+	stats, err := testApp.models.Brands.GetStats(brands[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ProductCount != 2 || stats.PriceMin != 100 || stats.PriceMax != 500 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if strings.Join(stats.Sizes, ",") != "M,XL" || strings.Join(stats.Colors, ",") != "blue,red" {
+		t.Errorf("expected the sizes and colors of the variants, got %v and %v", stats.Sizes, stats.Colors)
+	}
+}
+
+func TestArchiveBrandAndClothe(t *testing.T) {
+	brand := &data.Brand{Name: "archive", Country: "test", Description: "test", ImageURL: "test"}
+	err := testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clothe := &data.Clothe{
+		Name:       "Archived",
+		Price:      100,
+		BrandID:    brand.ID,
+		Color:      "red",
+		Sizes:      []string{"M"},
+		Sex:        "unisex",
+		CategoryID: category.ID,
+	}
+	err = testApp.models.Clothes.Insert(clothe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testApp.models.Brands.Delete(brand.ID)
+	if !errors.Is(err, data.ErrBrandInUse) {
+		t.Fatalf("expected a brand with clothes on sale to be in use, got %v", err)
+	}
+
+	err = testApp.models.Clothes.Delete(clothe.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testApp.models.Clothes.Get(clothe.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("expected an archived clothe to be hidden, got %v", err)
+	}
+	archived, err := testApp.models.Clothes.GetIncludingArchived(clothe.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived.DeletedAt == nil {
+		t.Errorf("expected deleted_at to be set")
+	}
+	err = testApp.models.Clothes.Delete(clothe.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("expected archiving twice to fail, got %v", err)
+	}
+
+	err = testApp.models.Brands.Delete(brand.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testApp.models.Brands.Get(brand.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("expected an archived brand to be hidden, got %v", err)
+	}
+
+	restored, err := testApp.models.Brands.Restore(brand.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.DeletedAt != nil {
+		t.Errorf("expected deleted_at to be cleared")
+	}
+	restoredClothe, err := testApp.models.Clothes.Restore(clothe.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restoredClothe.DeletedAt != nil {
+		t.Errorf("expected deleted_at to be cleared")
+	}
+}
+
+func TestValidateCategory(t *testing.T) {
+	parentID := int64(1)
+	tests := []struct {
+		category data.Category
+		valid    bool
+	}{
+		{data.Category{Name: "Women", Slug: "women"}, true},
+		{data.Category{Name: "Kids", Slug: "kids"}, false},
+		{data.Category{Name: "Kids", Slug: "kids", ParentID: &parentID}, true},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		data.ValidateCategory(v, &tt.category)
+		if v.Valid() != tt.valid {
+			t.Errorf("%s: expected valid to be %t, got %v", tt.category.Slug, tt.valid, v.Errors)
+		}
+	}
+	// Every sex a clothe can be listed by needs its root category.
+	for _, sex := range data.SexSafelist {
+		v := validator.New()
+		data.ValidateCategory(v, &data.Category{Name: sex, Slug: sex})
+		if !v.Valid() {
+			t.Errorf("%s: %v", sex, v.Errors)
+		}
+	}
+}
+
+func TestGetFacets(t *testing.T) {
+	brand := &data.Brand{Name: "facets", Country: "test", Description: "test", ImageURL: "test"}
+	err := testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clothes := []*data.Clothe{
+		{Name: "Hooded jacket", Price: 100, Color: "red", Sizes: []string{"S"}, Sex: "men"},
+		{Name: "Denim jeans", Price: 200, Color: "blue", Sizes: []string{"M", "L"}, Sex: "women"},
+		{Name: "Wool sweater", Price: 300, Color: "red", Sizes: []string{"L"}, Sex: "women"},
+	}
+	for _, clothe := range clothes {
+		clothe.BrandID = brand.ID
+		clothe.CategoryID = category.ID
+		err := testApp.models.Clothes.Insert(clothe)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The sizes and the price of the green sweater must not be counted when
+	// filtering by red.
+	price := int64(90000)
+	err = testApp.models.Variants.Insert(&data.ClotheVariant{ClotheID: clothes[2].ID, Color: "green", Sizes: []string{"XL"}, Price: &price})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := func(facet []data.FacetCount) map[string]int64 {
+		m := map[string]int64{}
+		for _, count := range facet {
+			m[count.Value] = count.Count
+		}
+		return m
+	}
+	// Each facet ignores its own filter and applies the others.
+	facets, err := testApp.models.Clothes.GetFacets(data.ClotheQuery{
+		BrandID: brand.ID,
+		Colors:  []string{"red"},
+		Sex:     "women",
+	}, data.PriceBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  map[string]int64
+		want map[string]int64
+	}{
+		{"colors", counts(facets.Colors), map[string]int64{"red": 1, "blue": 1, "green": 1}},
+		{"sex", counts(facets.Sex), map[string]int64{"men": 1, "women": 1}},
+		{"sizes", counts(facets.Sizes), map[string]int64{"L": 1}},
+		{"brands", counts(facets.Brands), map[string]int64{"facets": 1}},
+		{"categories", counts(facets.Categories), map[string]int64{"unisex": 1}},
+		{"prices", counts(facets.Prices), map[string]int64{"0-4999": 1}},
+	}
+	for _, tt := range tests {
+		if fmt.Sprint(tt.got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, tt.got)
+		}
+	}
+
+	// In a currency worth a hundredth of the base currency the bounds shrink,
+	// the labels stay in the display currency.
+	bounds := make([]int64, len(data.PriceBuckets))
+	for i, bucket := range data.PriceBuckets {
+		bounds[i] = bucket / 100
+	}
+	facets, err = testApp.models.Clothes.GetFacets(data.ClotheQuery{
+		BrandID: brand.ID,
+		Colors:  []string{"red"},
+		Sex:     "women",
+	}, bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"20000-49999": 1}
+	if got := counts(facets.Prices); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("prices: expected %v, got %v", want, got)
+	}
+}
+
+func TestGetAllClothesRelevance(t *testing.T) {
+	brand := &data.Brand{Name: "relevance", Country: "test", Description: "test", ImageURL: "test"}
+	err := testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Hooded jacket", "Hood"} {
+		err := testApp.models.Clothes.Insert(&data.Clothe{
+			Name:       name,
+			Price:      100,
+			BrandID:    brand.ID,
+			Color:      "black",
+			Sizes:      []string{"M"},
+			Sex:        "unisex",
+			CategoryID: category.ID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "relevance", SortSafelist: []string{"relevance"}}
+	clothes, _, err := testApp.models.Clothes.GetAll(data.ClotheQuery{Search: "hood", BrandID: brand.ID}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clothes) != 2 {
+		t.Fatalf("expected 2 clothes, got %d", len(clothes))
+	}
+	if clothes[0].Name != "Hood" {
+		t.Errorf("expected the closest match first, got %s, %s", clothes[0].Name, clothes[1].Name)
+	}
+}
+
+func TestServeUploads(t *testing.T) {
+	local := storage.NewLocal(t.TempDir(), "http://localhost:4000/media/")
+	if local.MountPath() != "/media" {
+		t.Fatalf("expected the mount path /media, got %q", local.MountPath())
+	}
+	location, err := local.Put(context.Background(), "brands/1/a.png", strings.NewReader("png"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{config: testApp.config, logger: testApp.logger, models: testApp.models, storage: local}
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, u.Path, nil))
+	if w.Code != http.StatusOK || w.Body.String() != "png" {
+		t.Errorf("expected the upload to be served at %s, got %d %q", u.Path, w.Code, w.Body.String())
+	}
+}
+
+func TestBuyVariant(t *testing.T) {
+	user := &data.User{
+		Name:      "variant",
+		Email:     fmt.Sprintf("variant-%d@example.com", time.Now().UnixNano()),
+		Activated: true,
+		Money:     100000,
+	}
+	user.Password.Set("test22222222222!")
+	err := testApp.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testApp.models.Carts.CreateCartForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	brand := &data.Brand{Name: "variants", Country: "test", Description: "test", ImageURL: "test"}
+	err = testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clothe := &data.Clothe{
+		Name:       "Variant",
+		Price:      1000,
+		BrandID:    brand.ID,
+		Color:      "red",
+		Sizes:      []string{"M"},
+		Sex:        "unisex",
+		CategoryID: category.ID,
+	}
+	err = testApp.models.Clothes.Insert(clothe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	price := int64(1500)
+	variant := &data.ClotheVariant{ClotheID: clothe.ID, Color: "blue", Sizes: []string{"L"}, Price: &price}
+	err = testApp.models.Variants.Insert(variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates, err := testApp.models.ExchangeRates.Rates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	charged, err := rates.Convert(price, rates.Base, user.Currency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{fmt.Sprintf("variant_id=%d&size=M", variant.ID), http.StatusUnprocessableEntity},
+		{fmt.Sprintf("variant_id=%d", variant.ID+1000000), http.StatusUnprocessableEntity},
+		{fmt.Sprintf("variant_id=%d&size=l", variant.ID), http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPut, "/v1/buy/"+strconv.FormatInt(clothe.ID, 10)+"?"+tt.query, nil)
+		params := httprouter.Params{{Key: "id", Value: strconv.FormatInt(clothe.ID, 10)}}
+		req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+		req = testApp.contextSetUser(req, user)
+
+		rr := httptest.NewRecorder()
+		testApp.addToCartHandler(rr, req)
+		if rr.Code != tt.want {
+			t.Fatalf("%s: expected status %d, got %d", tt.query, tt.want, rr.Code)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+		var response struct {
+			Charged int64 `json:"charged"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Charged != charged {
+			t.Errorf("expected the variant price %d to be charged, got %d", charged, response.Charged)
+		}
+	}
+}
+
+func TestWishlist(t *testing.T) {
+	user := &data.User{
+		Name:      "wishlist",
+		Email:     fmt.Sprintf("wishlist-%d@example.com", time.Now().UnixNano()),
+		Activated: true,
+	}
+	user.Password.Set("test22222222222!")
+	err := testApp.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := testApp.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	brand := &data.Brand{Name: "wishlist", Country: "test", Description: "test", ImageURL: "test"}
+	err = testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clothe := &data.Clothe{
+		Name:       "Wished",
+		Price:      100,
+		BrandID:    brand.ID,
+		Color:      "red",
+		Sizes:      []string{"M"},
+		Sex:        "unisex",
+		CategoryID: category.ID,
+	}
+	err = testApp.models.Clothes.Insert(clothe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token.Plaintext)
+		w := httptest.NewRecorder()
+		testApp.routes().ServeHTTP(w, req)
+		return w
+	}
+	list := func() []data.WishlistItem {
+		w := do(http.MethodGet, "/v1/users/me/wishlist")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		var response struct {
+			Wishlist []data.WishlistItem `json:"wishlist"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Wishlist
+	}
+	path := "/v1/users/me/wishlist/" + strconv.FormatInt(clothe.ID, 10)
+
+	// Adding twice keeps a single item.
+	for i := 0; i < 2; i++ {
+		if w := do(http.MethodPut, path); w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+	}
+	if w := do(http.MethodPut, "/v1/users/me/wishlist/0"); w.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for an unknown clothe, got %d", http.StatusNotFound, w.Code)
+	}
+	items := list()
+	if len(items) != 1 || items[0].Clothe.ID != clothe.ID {
+		t.Fatalf("expected the wishlist to hold clothe %d, got %+v", clothe.ID, items)
+	}
+	if !items[0].InStock || items[0].EffectivePrice == 0 {
+		t.Errorf("expected a priced clothe in stock, got %+v", items[0])
+	}
+	if w := do(http.MethodGet, "/v1/users/me/wishlist?page_size=0"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d for an invalid page size, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	w := do(http.MethodGet, "/v1/users/me/wishlist?page=1&page_size=1")
+	var page struct {
+		Wishlist []data.WishlistItem `json:"wishlist"`
+		Metadata data.Metadata       `json:"metadata"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Wishlist) != 1 || page.Metadata.TotalRecords != 1 || page.Metadata.LastPage != 1 {
+		t.Errorf("expected a single page of 1 item, got %d items and %+v", len(page.Wishlist), page.Metadata)
+	}
+
+	if w := do(http.MethodDelete, path); w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := do(http.MethodDelete, path); w.Code != http.StatusNotFound {
+		t.Errorf("expected removing twice to return %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if items := list(); len(items) != 0 {
+		t.Errorf("expected an empty wishlist, got %d items", len(items))
+	}
+}
+
+func TestMostWishlistedPaging(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"page_size=0", http.StatusUnprocessableEntity},
+		{"page_size=101", http.StatusUnprocessableEntity},
+		{"page=0", http.StatusUnprocessableEntity},
+		{"sort=name", http.StatusUnprocessableEntity},
+		{"page=1&page_size=1", http.StatusOK},
+		{"page_size=1&sort=id", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/reports/most-wishlisted?"+tt.query, nil)
+		rr := httptest.NewRecorder()
+		testApp.mostWishlistedHandler(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.query, tt.want, rr.Code)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+		var response struct {
+			Clothes []data.WishlistCount `json:"clothes"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Clothes) > 1 {
+			t.Errorf("expected at most 1 clothe, got %d", len(response.Clothes))
+		}
+	}
+}
+
+func TestRefreshRelatedClothes(t *testing.T) {
+	brand := &data.Brand{Name: "related", Country: "test", Description: "test", ImageURL: "test"}
+	err := testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newClothe := func(name, categorySlug string) *data.Clothe {
+		category, err := testApp.models.Categories.GetBySlug(categorySlug)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clothe := &data.Clothe{
+			Name:       name,
+			Price:      100,
+			BrandID:    brand.ID,
+			Color:      "red",
+			Sizes:      []string{"M"},
+			Sex:        "unisex",
+			CategoryID: category.ID,
+		}
+		err = testApp.models.Clothes.Insert(clothe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return clothe
+	}
+	// Clothes of different categories are only related by co-purchases.
+	bought := newClothe("Bought", "unisex")
+	together := newClothe("Bought together", "men")
+	archived := newClothe("Archived", "unisex")
+	alone := newClothe("Alone", "women")
+
+	user := &data.User{
+		Name:      "related",
+		Email:     fmt.Sprintf("related-%d@example.com", time.Now().UnixNano()),
+		Activated: true,
+	}
+	user.Password.Set("test22222222222!")
+	err = testApp.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testApp.models.Carts.CreateCartForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, clothe := range []*data.Clothe{bought, together} {
+		err = testApp.models.Carts.AddClotheForCart(user.ID, *clothe)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = testApp.models.Clothes.Delete(archived.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testApp.models.Related.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	related, err := testApp.models.Related.GetAll(bought.ID, 0, data.RelatedPerClothe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[int64]bool{}
+	for _, clothe := range related {
+		ids[clothe.ID] = true
+	}
+	if !ids[together.ID] {
+		t.Errorf("expected a clothe bought together to be related")
+	}
+	if ids[archived.ID] || ids[alone.ID] {
+		t.Errorf("expected archived and unrelated clothes to be left out, got %v", ids)
+	}
+
+	// The cart of the user leaves out what they already bought.
+	related, err = testApp.models.Related.GetAll(bought.ID, user.ID, data.RelatedPerClothe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, clothe := range related {
+		if clothe.ID == together.ID {
+			t.Errorf("expected a clothe in the cart to be left out")
+		}
+	}
+}
+
+func TestRefreshRelatedClothesStops(t *testing.T) {
+	testApp.shutdown = make(chan struct{})
+	testApp.refreshRelatedClothes(10 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	close(testApp.shutdown)
+
+	done := make(chan struct{})
+	go func() {
+		testApp.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the refresh loop to stop on shutdown")
+	}
+}
+
+func TestRunAlertsRequeues(t *testing.T) {
+	// Nothing listens on port 1, so firing the alerts fails.
+	db, err := sql.Open("postgres", "postgres://alerts@127.0.0.1:1/alerts?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	app := &application{
+		logger:   jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		models:   data.NewModels(db),
+		shutdown: make(chan struct{}),
+	}
+	close(app.shutdown)
+
+	app.fireAlerts(7)
+	app.wg.Wait()
+
+	app.alerts.mu.Lock()
+	defer app.alerts.mu.Unlock()
+	if !app.alerts.clothes[7] || app.alerts.running || app.alerts.failures != 1 {
+		t.Errorf("expected the clothe to be queued again after the failure, got %v, running %t, %d failures",
+			app.alerts.clothes, app.alerts.running, app.alerts.failures)
+	}
+}
+
+func TestUpdateClotheSizeSystem(t *testing.T) {
+	brand := &data.Brand{Name: "size systems", Country: "test", Description: "test", ImageURL: "test"}
+	err := testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clothe := &data.Clothe{
+		Name:       "Resized",
+		Price:      100,
+		BrandID:    brand.ID,
+		Color:      "red",
+		Sizes:      []string{"M"},
+		Sex:        "unisex",
+		CategoryID: category.ID,
+	}
+	err = testApp.models.Clothes.Insert(clothe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variant := &data.ClotheVariant{ClotheID: clothe.ID, Color: "blue", Sizes: []string{"L"}}
+	err = testApp.models.Variants.Insert(variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shoes, err := testApp.models.SizeSystems.GetBySlug("eu-shoe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func() *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"size_system_id": %d, "sizes": ["42"]}`, shoes.ID)
+		req := httptest.NewRequest(http.MethodPatch, "/v1/clothes/"+strconv.FormatInt(clothe.ID, 10), strings.NewReader(body))
+		params := httprouter.Params{{Key: "id", Value: strconv.FormatInt(clothe.ID, 10)}}
+		req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+		rr := httptest.NewRecorder()
+		testApp.updateClotheHandler(rr, req)
+		return rr
+	}
+
+	rr := update()
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "size_system_id") {
+		t.Fatalf("expected the variant sizes to block the change, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	err = testApp.models.Variants.Delete(clothe.ID, variant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := update(); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d without variants, got %d %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
+func TestClotheBundles(t *testing.T) {
+	brand := &data.Brand{Name: "bundles", Country: "test", Description: "test", ImageURL: "test"}
+	err := testApp.models.Brands.Insert(brand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category, err := testApp.models.Categories.GetBySlug("unisex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, name := range []string{"Bundled shirt", "Bundled trousers"} {
+		clothe := &data.Clothe{
+			Name:       name,
+			Price:      100,
+			BrandID:    brand.ID,
+			Color:      "red",
+			Sizes:      []string{"M"},
+			Sex:        "unisex",
+			CategoryID: category.ID,
+		}
+		err = testApp.models.Clothes.Insert(clothe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, clothe.ID)
+	}
+	percentOff := int64(10)
+	bundle := &data.Bundle{Name: "Outfit", PercentOff: &percentOff, ClotheIDs: ids}
+	err = testApp.models.Bundles.Insert(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundles, err := testApp.models.Bundles.GetAllForClothes(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if len(bundles[id]) != 1 || bundles[id][0].ID != bundle.ID || bundles[id][0].Name != "Outfit" {
+			t.Errorf("clothe %d: expected the bundle to be listed, got %v", id, bundles[id])
+		}
+	}
+
+	// A variant of its own price is charged at that price.
+	user := &data.User{
+		Name:      "bundle",
+		Email:     fmt.Sprintf("bundle-%d@example.com", time.Now().UnixNano()),
+		Activated: true,
+		Money:     100000,
+	}
+	user.Password.Set("test22222222222!")
+	err = testApp.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testApp.models.Carts.CreateCartForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	price := int64(500)
+	variant := &data.ClotheVariant{ClotheID: ids[0], Color: "blue", Sizes: []string{"L"}, Price: &price}
+	err = testApp.models.Variants.Insert(variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates, err := testApp.models.ExchangeRates.Rates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[int64]string{ids[0]: "L", ids[1]: "M"}
+	_, err = testApp.models.Bundles.Purchase(bundle.ID, user, sizes, nil, rates)
+	if !errors.Is(err, data.ErrBundleUnavailable) {
+		t.Errorf("expected L to be unavailable without the variant, got %v", err)
+	}
+	charged, err := testApp.models.Bundles.Purchase(bundle.ID, user, sizes, map[int64]int64{ids[0]: variant.ID}, rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10% off the 500 of the variant and the 100 of the other clothe.
+	want, err := rates.Convert(540, rates.Base, user.Currency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if charged != want {
+		t.Errorf("expected %d to be charged, got %d", want, charged)
+	}
+
+	// A bundle with an archived clothe is no longer on sale.
+	err = testApp.models.Clothes.Delete(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundles, err = testApp.models.Bundles.GetAllForClothes(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundles[ids[0]]) != 0 {
+		t.Errorf("expected no bundles, got %v", bundles[ids[0]])
+	}
+}
+
+// TestCatalogues checks that every validation and error message of the API
+// has an entry in the catalogue of every locale. Messages are looked up by
+// their text, so they have to be string literals.
+func TestCatalogues(t *testing.T) {
+	// The argument holding the message of the calls checked.
+	messageArg := map[string]int{"Check": 2, "AddError": 1, "errorResponse": 3, "Translate": 1}
+
+	fset := token.NewFileSet()
+	for _, dir := range []string{".", "../../internal/data", "../../internal/validator"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range files {
+			if strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			file, err := parser.ParseFile(fset, name, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ast.Inspect(file, func(n ast.Node) bool {
+				var message ast.Expr
+				switch n := n.(type) {
+				case *ast.AssignStmt:
+					// message := "..." in the error helpers.
+					if id, ok := n.Lhs[0].(*ast.Ident); ok && id.Name == "message" && len(n.Rhs) == 1 {
+						message = n.Rhs[0]
+					}
+				case *ast.CallExpr:
+					if sel, ok := n.Fun.(*ast.SelectorExpr); ok {
+						if i, ok := messageArg[sel.Sel.Name]; ok && len(n.Args) > i {
+							message = n.Args[i]
+						}
+					}
+				}
+				switch m := message.(type) {
+				case *ast.BasicLit:
+					text, err := strconv.Unquote(m.Value)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, locale := range i18n.Locales {
+						if locale != i18n.Default && !i18n.Has(locale, text) {
+							t.Errorf("%s: %q is missing from the %s catalogue", fset.Position(m.Pos()), text, locale)
+						}
+					}
+				case *ast.CallExpr:
+					// A message formatted from a literal never matches a
+					// catalogue entry, the format has to be translated first.
+					sel, ok := m.Fun.(*ast.SelectorExpr)
+					if ok && sel.Sel.Name == "Sprintf" && len(m.Args) > 0 {
+						if _, ok := m.Args[0].(*ast.BasicLit); ok {
+							t.Errorf("%s: messages must be fixed strings to be translated", fset.Position(m.Pos()))
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"clothing-store/internal/data"
+	"clothing-store/internal/i18n"
 	"clothing-store/internal/validator"
 	"errors"
 	"fmt"
 	"golang.org/x/time/rate"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -53,6 +55,17 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err == nil {
+			r = app.contextSetUser(r, user)
+			r = app.contextSetRealUser(r, user)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		user, impersonatorID, err := app.models.Users.GetForImpersonationToken(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -62,7 +75,24 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		realUser, err := app.models.Users.Get(impersonatorID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		app.logger.PrintInfo("impersonated request", map[string]string{
+			"real_user_id":   strconv.FormatInt(realUser.ID, 10),
+			"user_id":        strconv.FormatInt(user.ID, 10),
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+		})
 		r = app.contextSetUser(r, user)
+		r = app.contextSetRealUser(r, realUser)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -117,6 +147,24 @@ func (app *application) requireRole(role string, next http.HandlerFunc) http.Han
 	return app.requireActivatedUser(fn)
 }
 
+// forbidImpersonation guards endpoints that move money, so that support staff
+// acting as a customer can look around but never spend on their behalf.
+func (app *application) forbidImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextIsImpersonating(r) {
+			app.logger.PrintInfo("impersonated request blocked", map[string]string{
+				"real_user_id":   strconv.FormatInt(app.contextGetRealUser(r).ID, 10),
+				"user_id":        strconv.FormatInt(app.contextGetUser(r).ID, 10),
+				"request_method": r.Method,
+				"request_url":    r.URL.String(),
+			})
+			app.impersonationNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -126,3 +174,14 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// localize picks the locale of the response from the Accept-Language header.
+func (app *application) localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := i18n.Negotiate(r.Header.Get("Accept-Language"))
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", locale)
+
+		next.ServeHTTP(w, app.contextSetLocale(r, locale))
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/validator"
+	"errors"
+	"net/http"
+	"time"
+)
+
+func (app *application) listRelatedClothesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "limit", 10, v)
+	v.Check(limit >= 1 && limit <= data.RelatedPerClothe, "limit", "must be between 1 and 20")
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	currency := app.readCurrency(w, r, rates, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	_, err = app.models.Clothes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Anonymous users have no cart, nothing is left out for them.
+	user := app.contextGetUser(r)
+
+	clothes, err := app.models.Related.GetAll(id, user.ID, int(limit))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.convertClothes(clothes, rates, currency)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Translations.LocalizeClothes(clothes, app.contextGetLocale(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"clothes": clothes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshRelatedClothes rebuilds the recommendations now and then every
+// interval until the server shuts down. The loop is a background task, so a
+// shutdown waits for a running refresh to finish.
+func (app *application) refreshRelatedClothes(interval time.Duration) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			start := time.Now()
+			err := app.models.Related.Refresh()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			} else {
+				app.logger.PrintInfo("related clothes refreshed", map[string]string{
+					"duration": time.Since(start).String(),
+				})
+			}
+			select {
+			case <-ticker.C:
+			case <-app.shutdown:
+				return
+			}
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/storage"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
 )
@@ -16,20 +18,100 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/clothes/:id", app.showClotheHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/clothes/:id", app.requireRole("ADMIN", app.updateClotheHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/clothes/:id", app.requireRole("ADMIN", app.deleteClotheHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/clothes/:id/restore", app.requireRole("ADMIN", app.restoreClotheHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/clothes/:id/variants", app.requireRole("ADMIN", app.createClotheVariantHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/clothes/:id/variants/:variant_id", app.requireRole("ADMIN", app.updateClotheVariantHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/clothes/:id/variants/:variant_id", app.requireRole("ADMIN", app.deleteClotheVariantHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/clothes/:id/reviews", app.listClotheReviewsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/clothes/:id/reviews", app.requireActivatedUser(app.forbidImpersonation(app.createReviewHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/clothes/:id/related", app.listRelatedClothesHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/clothes/:id/size-recommendation", app.sizeRecommendationHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/clothes/:id/alerts", app.requireActivatedUser(app.createAlertHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/clothes/:id/images", app.listClotheImagesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/clothes/:id/images", app.requireRole("ADMIN", app.uploadClotheImageHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/clothes/:id/images/:image_id", app.requireRole("ADMIN", app.updateClotheImageHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/clothes/:id/images/:image_id", app.requireRole("ADMIN", app.deleteClotheImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/clothes/:id/translations", app.requireRole("ADMIN", app.listTranslationsHandler(data.TranslatableClothe)))
+	router.HandlerFunc(http.MethodPut, "/v1/clothes/:id/translations/:locale", app.requireRole("ADMIN", app.putTranslationHandler(data.TranslatableClothe)))
+	router.HandlerFunc(http.MethodDelete, "/v1/clothes/:id/translations/:locale", app.requireRole("ADMIN", app.deleteTranslationHandler(data.TranslatableClothe)))
 
 	router.HandlerFunc(http.MethodGet, "/v1/brands", app.listBrandsHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/brands", app.requireRole("ADMIN", app.createBrandHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/brands/:id", app.showBrandHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/brands/:id/clothes", app.listBrandClothesHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/brands/:id", app.requireRole("ADMIN", app.updateBrandHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/brands/:id", app.requireRole("ADMIN", app.deleteBrandHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/brands/:id/restore", app.requireRole("ADMIN", app.restoreBrandHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/brands/:id/image", app.requireRole("ADMIN", app.uploadBrandImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/brands/:id/size-charts", app.listBrandSizeChartsHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/brands/:id/size-charts/:size_system_id", app.requireRole("ADMIN", app.replaceBrandSizeChartHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/brands/:id/translations", app.requireRole("ADMIN", app.listTranslationsHandler(data.TranslatableBrand)))
+	router.HandlerFunc(http.MethodPut, "/v1/brands/:id/translations/:locale", app.requireRole("ADMIN", app.putTranslationHandler(data.TranslatableBrand)))
+	router.HandlerFunc(http.MethodDelete, "/v1/brands/:id/translations/:locale", app.requireRole("ADMIN", app.deleteTranslationHandler(data.TranslatableBrand)))
 
-	router.HandlerFunc(http.MethodPut, "/v1/buy/:id", app.requireRole("USER", app.addToCartHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/exchange-rates", app.listExchangeRatesHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/exchange-rates/:currency", app.requireRole("ADMIN", app.putExchangeRateHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/exchange-rates/:currency", app.requireRole("ADMIN", app.deleteExchangeRateHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/size-systems", app.listSizeSystemsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/size-systems", app.requireRole("ADMIN", app.createSizeSystemHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/size-systems/:id", app.showSizeSystemHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/search/suggest", app.suggestHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/reviews", app.requireRole("ADMIN", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requireRole("ADMIN", app.moderateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requireRole("ADMIN", app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/categories", app.listCategoriesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/categories", app.requireRole("ADMIN", app.createCategoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id", app.showCategoryHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/categories/:id", app.requireRole("ADMIN", app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id", app.requireRole("ADMIN", app.deleteCategoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id/translations", app.requireRole("ADMIN", app.listTranslationsHandler(data.TranslatableCategory)))
+	router.HandlerFunc(http.MethodPut, "/v1/categories/:id/translations/:locale", app.requireRole("ADMIN", app.putTranslationHandler(data.TranslatableCategory)))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id/translations/:locale", app.requireRole("ADMIN", app.deleteTranslationHandler(data.TranslatableCategory)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/bundles", app.listBundlesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/bundles", app.requireRole("ADMIN", app.createBundleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/bundles/:id", app.showBundleHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/bundles/:id", app.requireRole("ADMIN", app.updateBundleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/bundles/:id", app.requireRole("ADMIN", app.deleteBundleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/bundles/:id/purchase", app.requireRole("USER", app.forbidImpersonation(app.purchaseBundleHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.listCollectionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requireRole("ADMIN", app.createCollectionHandler))
+	// Collections are shown by slug, the admin routes address them by id.
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:slug", app.showCollectionHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requireRole("ADMIN", app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requireRole("ADMIN", app.deleteCollectionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:id/clothes", app.requireRole("ADMIN", app.replaceCollectionClothesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections/:id/hero-image", app.requireRole("ADMIN", app.uploadCollectionHeroImageHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/buy/:id", app.requireRole("USER", app.forbidImpersonation(app.addToCartHandler)))
 	router.HandlerFunc(http.MethodGet, "/v1/cart", app.requireRole("USER", app.showCartHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", app.requireRole("ADMIN", app.deleteUserHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireActivatedUser(app.forbidImpersonation(app.exportUserHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/wishlist", app.requireActivatedUser(app.showWishlistHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/wishlist/:clothe_id", app.requireActivatedUser(app.addToWishlistHandler))
+	// Registered with a wildcard, a static "me" would conflict with
+	// DELETE /v1/users/:id. The handler only accepts "me".
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/wishlist/:clothe_id", app.requireActivatedUser(app.removeFromWishlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/alerts", app.requireActivatedUser(app.listAlertsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/alerts/:alert_id", app.requireActivatedUser(app.deleteAlertHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/reports/most-wishlisted", app.requireRole("ADMIN", app.mostWishlistedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/erasure", app.requireActivatedUser(app.forbidImpersonation(app.eraseCurrentUserHandler)))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/impersonation", app.requireRole("ADMIN", app.forbidImpersonation(app.createImpersonationTokenHandler)))
+
+	// Uploads kept by the local storage, at the path of the URLs it hands out.
+	// With S3 the files are served by the bucket.
+	if local, ok := app.storage.(storage.Local); ok && local.MountPath() != "" {
+		router.ServeFiles(local.MountPath()+"/*filepath", http.Dir(local.Dir))
+	}
 
-	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+	return app.recoverPanic(app.localize(app.enableCORS(app.rateLimit(app.authenticate(router)))))
 }
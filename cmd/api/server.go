@@ -35,6 +35,9 @@ func (app *application) serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
+		// Stop the periodic background tasks so that they do not keep the
+		// WaitGroup busy.
+		close(app.shutdown)
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"errors"
+	"net/http"
+)
+
+func (app *application) sizeRecommendationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	clothe, err := app.models.Clothes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	system, err := app.models.SizeSystems.Get(clothe.SizeSystemID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	charts, err := app.models.SizeCharts.GetAllForBrand(clothe.BrandID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var chart []data.SizeChartRow
+	for _, c := range charts {
+		if c.SizeSystemID == system.ID {
+			chart = c.Rows
+		}
+	}
+
+	// Anonymous users have no history, they get the fallback recommendation.
+	user := app.contextGetUser(r)
+
+	var history []data.SizePurchase
+	if !user.IsAnonymous() {
+		history, err = app.models.Sizes.History(user.ID, clothe)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	clotheFit, brandFit, err := app.models.Sizes.Fit(clothe)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	popular, err := app.models.Sizes.PopularSize(clothe)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	recommendation := data.RecommendSize(clothe, system, history, chart, clotheFit, brandFit, popular)
+	if recommendation == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recommendation": recommendation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
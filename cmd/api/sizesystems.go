@@ -0,0 +1,178 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (app *application) listSizeSystemsHandler(w http.ResponseWriter, r *http.Request) {
+	systems, err := app.models.SizeSystems.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"size_systems": systems}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSizeSystemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	system, err := app.models.SizeSystems.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"size_system": system}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createSizeSystemHandler(w http.ResponseWriter, r *http.Request) {
+	// Sizes are listed from the smallest to the largest.
+	var input struct {
+		Name  string   `json:"name"`
+		Slug  string   `json:"slug"`
+		Sizes []string `json:"sizes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	system := &data.SizeSystem{
+		Name:  input.Name,
+		Slug:  input.Slug,
+		Sizes: input.Sizes,
+	}
+	v := validator.New()
+
+	if data.ValidateSizeSystem(v, system); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.SizeSystems.Insert(system)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a size system with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/size-systems/%d", system.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"size_system": system}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBrandSizeChartsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Brands.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	charts, err := app.models.SizeCharts.GetAllForBrand(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"size_charts": charts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replaceBrandSizeChartHandler sets the whole size chart of a brand in one size
+// system, an empty list of rows removes it.
+func (app *application) replaceBrandSizeChartHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	sizeSystemID, err := app.readNamedIDParam(r, "size_system_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Brands.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	system, err := app.models.SizeSystems.Get(sizeSystemID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Rows []data.SizeChartRow `json:"rows"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateSizeChart(v, input.Rows, system); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.SizeCharts.Replace(id, system.ID, input.Rows)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	chart := data.SizeChart{SizeSystemID: system.ID, SizeSystem: system.Slug, Rows: input.Rows}
+	if chart.Rows == nil {
+		chart.Rows = []data.SizeChartRow{}
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"size_chart": chart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"clothing-store/internal/validator"
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -59,6 +60,11 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	err = app.audit(r, user.ID, 0, data.AuditLogin)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Encode the token to JSON and send it in the response along with a 201 Created
 	// status code.
 	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
@@ -66,3 +72,62 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) createImpersonationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	admin := app.contextGetRealUser(r)
+	v := validator.New()
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(input.UserID != admin.ID, "user_id", "must not be your own id")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.Get(input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Impersonating another admin would be a way to borrow their privileges, so
+	// only customer accounts can be impersonated.
+	roles, err := app.models.Roles.GetAllRolesForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if roles.Include("ADMIN") {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	token, err := app.models.Tokens.NewImpersonation(user.ID, admin.ID, 15*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.audit(r, user.ID, admin.ID, data.AuditImpersonation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.logger.PrintInfo("impersonation token issued", map[string]string{
+		"real_user_id": strconv.FormatInt(admin.ID, 10),
+		"user_id":      strconv.FormatInt(user.ID, 10),
+		"expiry":       token.Expiry.Format(time.RFC3339),
+	})
+	err = app.writeJSON(w, http.StatusCreated, envelope{"impersonation_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
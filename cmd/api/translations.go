@@ -0,0 +1,130 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/validator"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// getTranslatable checks that the record the translations belong to exists.
+func (app *application) getTranslatable(kind data.Translatable, id int64) error {
+	var err error
+	switch kind {
+	case data.TranslatableClothe:
+		_, err = app.models.Clothes.Get(id)
+	case data.TranslatableBrand:
+		_, err = app.models.Brands.Get(id)
+	case data.TranslatableCategory:
+		_, err = app.models.Categories.Get(id)
+	}
+	return err
+}
+
+func (app *application) listTranslationsHandler(kind data.Translatable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		err = app.getTranslatable(kind, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		translations, err := app.models.Translations.GetAll(kind, id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"translations": translations}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// putTranslationHandler sets the texts of a record in the locale of the URL.
+// Texts left empty fall back to the next locale.
+func (app *application) putTranslationHandler(kind data.Translatable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		var input struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		translation := &data.Translation{
+			Locale:      httprouter.ParamsFromContext(r.Context()).ByName("locale"),
+			Name:        input.Name,
+			Description: input.Description,
+		}
+		v := validator.New()
+
+		if data.ValidateTranslation(v, kind, translation); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		err = app.getTranslatable(kind, id)
+		if err == nil {
+			err = app.models.Translations.Upsert(kind, id, translation)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"translation": translation}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) deleteTranslationHandler(kind data.Translatable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		locale := httprouter.ParamsFromContext(r.Context()).ByName("locale")
+
+		err = app.models.Translations.Delete(kind, id, locale)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": "translation successfully deleted"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
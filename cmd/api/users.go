@@ -2,17 +2,30 @@ package main
 
 import (
 	"clothing-store/internal/data"
+	"clothing-store/internal/i18n"
 	"clothing-store/internal/validator"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// startingBalance is the money new users get, in whole units of the base
+// currency.
+const startingBalance = 100000
+
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// Currency is the currency of the wallet, the base currency when
+		// empty. It cannot be changed later on.
+		Currency string `json:"currency"`
+		// Locale is the locale emails are sent in, the locale of the request
+		// when empty.
+		Locale string `json:"locale"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -22,8 +35,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	user := &data.User{
 		Name:      input.Name,
 		Email:     input.Email,
+		Locale:    input.Locale,
 		Activated: false,
-		Money:     100000,
+	}
+	if user.Locale == "" {
+		user.Locale = app.contextGetLocale(r)
 	}
 	err = user.Password.Set(input.Password)
 	if err != nil {
@@ -31,6 +47,25 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	v := validator.New()
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	user.Currency = rates.Base
+	if input.Currency != "" {
+		user.Currency = strings.ToUpper(input.Currency)
+		v.Check(rates.Supports(user.Currency), "currency", "unsupported currency")
+	}
+	v.Check(i18n.Supported(user.Locale), "locale", "must be one of the supported locales")
+	if v.Valid() {
+		// The starting balance is set in whole units of the base currency.
+		user.Money, err = rates.Convert(rates.Amount(startingBalance, rates.Base), rates.Base, user.Currency)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 	if data.ValidateUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -69,7 +104,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		data := map[string]any{
 			"activationToken": token.Plaintext,
 		}
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err = app.mailer.Send(user.Email, user.Locale, "user_welcome.tmpl", data)
 		if err != nil {
 			app.logger.PrintError(err, nil)
 		}
@@ -129,6 +164,124 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// exportUserHandler sends the personal data kept about the user: the profile,
+// the cart and the sizes bought, the reviews, the wishlist, the stock alerts,
+// the tokens metadata and the audit entries.
+func (app *application) exportUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Each download is recorded, the export lists its own entry.
+	err := app.audit(r, user.ID, 0, data.AuditExport)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllRolesForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	cartIDs, err := app.models.Carts.GetById(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	cartClothes, err := app.models.Clothes.GetByIDs(cartIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	purchaseSizes, err := app.models.Sizes.GetAllPurchases(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	reviews, err := app.models.Reviews.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	wishlist := []*data.WishlistItem{}
+	filters := data.Filters{Page: 1, PageSize: 100, Sort: "-added_at", SortSafelist: []string{"-added_at"}}
+	for {
+		items, metadata, err := app.models.Wishlists.GetAll(user.ID, filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		wishlist = append(wishlist, items...)
+		if filters.Page >= metadata.LastPage {
+			break
+		}
+		filters.Page++
+	}
+	alerts, err := app.models.Alerts.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	tokens, err := app.models.Tokens.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	auditEntries, err := app.models.Audit.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The cart doubles as the purchase history, since every item in it has
+	// already been paid for.
+	export := envelope{
+		"exported_at": time.Now(),
+		"profile":     user,
+		"roles":       roles,
+		"cart": envelope{
+			"clothes_id": cartIDs,
+			"clothes":    cartClothes,
+			"sizes":      purchaseSizes,
+		},
+		"reviews":       reviews,
+		"wishlist":      wishlist,
+		"alerts":        alerts,
+		"tokens":        tokens,
+		"audit_entries": auditEntries,
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, user.ID))
+	err = app.writeJSON(w, http.StatusOK, export, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) eraseCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Users.Anonymize(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Audit.Insert(&data.AuditEntry{UserID: user.ID, Action: data.AuditErasure})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user data successfully erased"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -136,7 +289,7 @@ func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Users.Delete(id)
+	err = app.models.Users.Anonymize(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -146,7 +299,14 @@ func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	// The address of the admin is not the personal data of the user and is
+	// kept.
+	err = app.audit(r, id, app.contextGetRealUser(r).ID, data.AuditErasure)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user data successfully erased"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"clothing-store/internal/data"
+	"clothing-store/internal/validator"
+	"errors"
+	"net/http"
+)
+
+func (app *application) createClotheVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	clothe, err := app.models.Clothes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Color    string   `json:"color"`
+		Sizes    []string `json:"sizes"`
+		Price    *int64   `json:"price"`
+		Position int64    `json:"position"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	system, err := app.models.SizeSystems.Get(clothe.SizeSystemID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	variant := &data.ClotheVariant{
+		ClotheID: clothe.ID,
+		Color:    input.Color,
+		Sizes:    input.Sizes,
+		Price:    input.Price,
+		Position: input.Position,
+	}
+	v := validator.New()
+
+	if data.ValidateClotheVariant(v, variant, clothe.Color, system); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Variants.Insert(variant)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateColor):
+			v.AddError("color", "the clothe already has a variant of this color")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.fireAlerts(clothe.ID)
+	err = app.writeJSON(w, http.StatusCreated, variant, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateClotheVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	variantID, err := app.readNamedIDParam(r, "variant_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	clothe, err := app.models.Clothes.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	variant, err := app.models.Variants.Get(clothe.ID, variantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// A price of 0 removes the override.
+	var input struct {
+		Color    *string  `json:"color"`
+		Sizes    []string `json:"sizes"`
+		Price    *int64   `json:"price"`
+		Position *int64   `json:"position"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Color != nil {
+		variant.Color = *input.Color
+	}
+	if input.Sizes != nil {
+		variant.Sizes = input.Sizes
+	}
+	if input.Price != nil {
+		variant.Price = input.Price
+		if *input.Price == 0 {
+			variant.Price = nil
+		}
+	}
+	if input.Position != nil {
+		variant.Position = *input.Position
+	}
+	system, err := app.models.SizeSystems.Get(clothe.SizeSystemID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateClotheVariant(v, variant, clothe.Color, system); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Variants.Update(variant)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateColor):
+			v.AddError("color", "the clothe already has a variant of this color")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.fireAlerts(clothe.ID)
+	err = app.writeJSON(w, http.StatusOK, variant, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteClotheVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	variantID, err := app.readNamedIDParam(r, "variant_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Variants.Delete(id, variantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "variant successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setClotheVariants loads the variants of the clothes. With images set the
+// gallery of each clothe is split between the clothe and its variants, which
+// only the single clothe response does.
+func (app *application) setClotheVariants(clothes []*data.Clothe, images bool) error {
+	if len(clothes) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(clothes))
+	for i, clothe := range clothes {
+		ids[i] = clothe.ID
+	}
+	variants, err := app.models.Variants.GetAllForClothes(ids)
+	if err != nil {
+		return err
+	}
+	for _, clothe := range clothes {
+		clothe.Variants = variants[clothe.ID]
+		if !images {
+			continue
+		}
+		gallery, err := app.models.Images.GetAllForClothe(clothe.ID)
+		if err != nil {
+			return err
+		}
+		clothe.Images = []data.ClotheImage{}
+		for _, image := range gallery {
+			if image.VariantID == nil {
+				clothe.Images = append(clothe.Images, image)
+				continue
+			}
+			for i := range clothe.Variants {
+				if clothe.Variants[i].ID == *image.VariantID {
+					clothe.Variants[i].Images = append(clothe.Variants[i].Images, image)
+				}
+			}
+		}
+	}
+	return nil
+}
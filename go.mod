@@ -1,12 +1,15 @@
 module clothing-store
 
-go 1.18
+go 1.22.2
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
-	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/lib/pq v1.10.2 // indirect
-	golang.org/x/crypto v0.6.0 // indirect
-	golang.org/x/time v0.3.0 // indirect
-	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+	github.com/HugoSmits86/nativewebp v0.9.3
+	github.com/go-mail/mail/v2 v2.3.0
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.2
+	golang.org/x/crypto v0.6.0
+	golang.org/x/image v0.10.0
+	golang.org/x/time v0.3.0
 )
+
+require gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
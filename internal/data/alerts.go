@@ -0,0 +1,235 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+const (
+	AlertBackInStock = "back_in_stock"
+	AlertPriceDrop   = "price_drop"
+)
+
+var AlertKindSafelist = []string{AlertBackInStock, AlertPriceDrop}
+
+var ErrAlertNotNeeded = errors.New("alert not needed")
+
+// Alert asks for an email once a size of a clothe is available again or once
+// its price goes below Price. Alerts are deleted as soon as they fire.
+type Alert struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	ClotheID  int64     `json:"clothe_id"`
+	Kind      string    `json:"kind"`
+	Size      string    `json:"size,omitempty"`
+	Price     int64     `json:"price,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FiredAlert is an alert that has fired, together with what is needed to write
+// the email about it.
+type FiredAlert struct {
+	Alert
+	UserEmail  string
+	UserName   string
+	UserLocale string
+	// UserCurrency is the currency of the wallet, prices are mailed in it.
+	UserCurrency string
+	ClotheName   string
+	NewPrice     int64
+}
+
+// ValidateAlert checks the alert against the size system of its clothe. A
+// back_in_stock alert may await any size of the system, whether a variant
+// offers it or not.
+func ValidateAlert(v *validator.Validator, alert *Alert, system *SizeSystem) {
+	v.Check(validator.PermittedValue(alert.Kind, AlertKindSafelist...), "kind", "must be either back_in_stock or price_drop")
+	if alert.Kind == AlertBackInStock {
+		v.Check(alert.Size != "", "size", "must be provided")
+		v.Check(alert.Size == "" || system.Permits(strings.ToUpper(alert.Size)), "size", "must be a size of the size system of the clothe")
+	} else {
+		v.Check(alert.Size == "", "size", "must only be provided for back_in_stock alerts")
+	}
+}
+
+type AlertModel struct {
+	DB *sql.DB
+}
+
+// Insert subscribes the user, a price_drop alert remembers the current lowest
+// price of the clothe. ErrAlertNotNeeded is returned for a back_in_stock alert
+// on a size that is already available. Subscribing twice returns the existing
+// alert.
+func (m AlertModel) Insert(alert *Alert) error {
+	alert.Size = strings.ToUpper(alert.Size)
+	query := fmt.Sprintf(`
+		WITH clothe AS (
+			SELECT clothes.deleted_at IS NULL AS on_sale,
+				(SELECT MIN(variant.price) FROM %s AS variant) AS price,
+				ARRAY(SELECT unnest(variant.sizes) FROM %s AS variant) AS sizes
+			FROM clothes
+			WHERE clothes.id = $2
+		)
+		INSERT INTO alerts (user_id, clothe_id, kind, size, price)
+		SELECT $1, $2, $3, $4, clothe.price
+		FROM clothe
+		WHERE NOT ($3 = 'back_in_stock' AND clothe.on_sale AND upper($4) = ANY(clothe.sizes))
+		ON CONFLICT (user_id, clothe_id, kind, size) DO UPDATE SET kind = EXCLUDED.kind
+		RETURNING id, price, created_at`, clotheVariantSet, clotheVariantSet)
+	args := []any{alert.UserID, alert.ClotheID, alert.Kind, alert.Size}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&alert.ID, &alert.Price, &alert.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrAlertNotNeeded
+		default:
+			return err
+		}
+	}
+	if alert.Kind == AlertBackInStock {
+		alert.Price = 0
+	}
+	return nil
+}
+
+func (m AlertModel) GetAllForUser(userID int64) ([]*Alert, error) {
+	query := `
+		SELECT id, user_id, clothe_id, kind, size, price, created_at
+		FROM alerts
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []*Alert{}
+	for rows.Next() {
+		var alert Alert
+		err := rows.Scan(
+			&alert.ID,
+			&alert.UserID,
+			&alert.ClotheID,
+			&alert.Kind,
+			&alert.Size,
+			&alert.Price,
+			&alert.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if alert.Kind == AlertBackInStock {
+			alert.Price = 0
+		}
+		alerts = append(alerts, &alert)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (m AlertModel) Delete(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM alerts
+		WHERE user_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Fire deletes and returns the alerts of the clothes whose condition is met
+// now: the awaited size is offered by a variant of a clothe on sale, or the
+// lowest variant price is below the price the alert was created at. The alerts
+// are ordered by user and clothe.
+func (m AlertModel) Fire(clotheIDs []int64) ([]*FiredAlert, error) {
+	query := fmt.Sprintf(`
+		WITH clothe AS (
+			SELECT clothes.id, clothes.name,
+				(SELECT MIN(variant.price) FROM %s AS variant) AS price,
+				ARRAY(SELECT unnest(variant.sizes) FROM %s AS variant) AS sizes
+			FROM clothes
+			WHERE clothes.id = ANY($1) AND clothes.deleted_at IS NULL
+		), fired AS (
+			DELETE FROM alerts
+			USING clothe
+			WHERE alerts.clothe_id = clothe.id
+			AND ((alerts.kind = 'back_in_stock' AND upper(alerts.size) = ANY(clothe.sizes))
+				OR (alerts.kind = 'price_drop' AND clothe.price < alerts.price))
+			RETURNING alerts.*
+		)
+		SELECT fired.id, fired.user_id, fired.clothe_id, fired.kind, fired.size, fired.price, fired.created_at,
+			users.email, users.name, users.locale, users.currency, clothe.name, clothe.price
+		FROM fired
+		INNER JOIN users ON users.id = fired.user_id
+		INNER JOIN clothe ON clothe.id = fired.clothe_id
+		ORDER BY fired.user_id, fired.clothe_id, fired.id`, clotheVariantSet, clotheVariantSet)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(clotheIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []*FiredAlert{}
+	for rows.Next() {
+		var alert FiredAlert
+		err := rows.Scan(
+			&alert.ID,
+			&alert.UserID,
+			&alert.ClotheID,
+			&alert.Kind,
+			&alert.Size,
+			&alert.Price,
+			&alert.CreatedAt,
+			&alert.UserEmail,
+			&alert.UserName,
+			&alert.UserLocale,
+			&alert.UserCurrency,
+			&alert.ClotheName,
+			&alert.NewPrice,
+		)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, &alert)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
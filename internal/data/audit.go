@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The actions recorded in the audit entries.
+const (
+	AuditLogin         = "login"
+	AuditImpersonation = "impersonation"
+	AuditExport        = "export"
+	AuditErasure       = "erasure"
+)
+
+// AuditEntry records an action on the account of a user. ActorID is the admin
+// who acted on the account, 0 when the user acted themselves.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	ActorID    int64     `json:"actor_id,omitempty"`
+	Action     string    `json:"action"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m AuditModel) Insert(entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_entries (user_id, actor_id, action, remote_addr)
+		VALUES ($1, NULLIF($2, 0), $3, $4)
+		RETURNING id, created_at`
+	args := []any{entry.UserID, entry.ActorID, entry.Action, entry.RemoteAddr}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAllForUser lists the audit entries of the user, oldest first.
+func (m AuditModel) GetAllForUser(userID int64) ([]*AuditEntry, error) {
+	query := `
+		SELECT id, user_id, COALESCE(actor_id, 0), action, remote_addr, created_at
+		FROM audit_entries
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.ActorID, &entry.Action, &entry.RemoteAddr, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -6,7 +6,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"github.com/lib/pq"
 	"time"
 )
 
@@ -16,8 +16,35 @@ type Brand struct {
 	Country     string `json:"country"`
 	Description string `json:"description"`
 	ImageURL    string `json:"image_url,omitempty"`
+	// ImageStorageKey is set when the image was uploaded rather than linked.
+	ImageStorageKey string      `json:"-"`
+	Srcset          Srcset      `json:"srcset,omitempty"`
+	DeletedAt       *time.Time  `json:"deleted_at,omitempty"`
+	Stats           *BrandStats `json:"stats,omitempty"`
 }
 
+// BrandStats aggregates the clothes a brand currently has on sale.
+type BrandStats struct {
+	ProductCount int64 `json:"product_count"`
+	PriceMin     int64 `json:"price_min"`
+	PriceMax     int64 `json:"price_max"`
+	// Currency is the currency of the price range, it is set when the prices
+	// are converted for display.
+	Currency string   `json:"currency,omitempty"`
+	Sizes    []string `json:"sizes"`
+	Colors   []string `json:"colors"`
+}
+
+// BrandInfo is the part of a brand embedded into clothe responses.
+type BrandInfo struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Country  string `json:"country"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+var ErrBrandInUse = errors.New("brand in use")
+
 func ValidateBrand(v *validator.Validator, brand *Brand) {
 	v.Check(brand.Name != "", "name", "must be provided")
 	v.Check(brand.Country != "", "country", "must be provided")
@@ -38,21 +65,32 @@ func (m BrandModel) Insert(brand *Brand) error {
 }
 
 func (m BrandModel) Get(id int64) (*Brand, error) {
+	return m.get(id, false)
+}
+
+func (m BrandModel) GetIncludingArchived(id int64) (*Brand, error) {
+	return m.get(id, true)
+}
+
+func (m BrandModel) get(id int64, includeArchived bool) (*Brand, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-		SELECT *
+		SELECT id, name, country, description, image_url, image_storage_key, image_variants, deleted_at
 		FROM brands
-		WHERE id = $1`
+		WHERE id = $1 AND (deleted_at IS NULL OR $2)`
 	var brand Brand
 
-	err := m.DB.QueryRow(query, id).Scan(
+	err := m.DB.QueryRow(query, id, includeArchived).Scan(
 		&brand.ID,
 		&brand.Name,
 		&brand.Country,
 		&brand.Description,
 		&brand.ImageURL,
+		&brand.ImageStorageKey,
+		&brand.Srcset,
+		&brand.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -68,26 +106,60 @@ func (m BrandModel) Get(id int64) (*Brand, error) {
 func (m BrandModel) Update(brand *Brand) error {
 	query := `
 			UPDATE brands
-			SET name = $1, country = $2, description = $3, image_url = $4
-			WHERE id = $5
+			SET name = $1, country = $2, description = $3, image_url = $4,
+			    image_storage_key = $5, image_variants = $6
+			WHERE id = $7 AND deleted_at IS NULL
 			RETURNING id`
 	args := []any{
 		brand.Name,
 		brand.Country,
 		brand.Description,
 		brand.ImageURL,
+		brand.ImageStorageKey,
+		brand.Srcset,
 		brand.ID,
 	}
 	return m.DB.QueryRow(query, args...).Scan(&brand.ID)
 }
 
+// SetImageVariants records the resized variants of an uploaded brand image.
+// ErrRecordNotFound is returned when the brand has got another image in the
+// meantime.
+func (m BrandModel) SetImageVariants(id int64, storageKey string, srcset Srcset) error {
+	query := `
+			UPDATE brands
+			SET image_variants = $1
+			WHERE id = $2 AND image_storage_key = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, srcset, id, storageKey)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete archives the brand. Its row is kept so that clothes and carts
+// referring to it remain resolvable. A brand that still has clothes on sale
+// cannot be archived and ErrBrandInUse is returned instead.
 func (m BrandModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `
-				DELETE FROM brands
-				WHERE id = $1`
+				UPDATE brands
+				SET deleted_at = NOW()
+				WHERE id = $1 AND deleted_at IS NULL
+				AND NOT EXISTS (SELECT 1 FROM clothes WHERE clothes.brand_id = brands.id AND clothes.deleted_at IS NULL)`
 	result, err := m.DB.Exec(query, id)
 	if err != nil {
 		return err
@@ -97,65 +169,120 @@ func (m BrandModel) Delete(id int64) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		_, err := m.Get(id)
+		if err != nil {
+			return err
+		}
+		return ErrBrandInUse
 	}
 	return nil
 }
 
-func (m BrandModel) GetAll() ([]*Brand, error) {
+func (m BrandModel) Restore(id int64) (*Brand, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+				UPDATE brands
+				SET deleted_at = NULL
+				WHERE id = $1 AND deleted_at IS NOT NULL
+				RETURNING id, name, country, description, image_url, image_storage_key, image_variants, deleted_at`
+	var brand Brand
+
+	err := m.DB.QueryRow(query, id).Scan(
+		&brand.ID,
+		&brand.Name,
+		&brand.Country,
+		&brand.Description,
+		&brand.ImageURL,
+		&brand.ImageStorageKey,
+		&brand.Srcset,
+		&brand.DeletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &brand, nil
+}
+
+func (m BrandModel) GetAll(name string, country string, includeArchived bool, filters Filters) ([]*Brand, Metadata, error) {
 	query := fmt.Sprintf(`
-								SELECT *
-								FROM brands ORDER BY id`)
+								SELECT count(*) OVER(), id, name, country, description, image_url, image_storage_key, image_variants, deleted_at
+								FROM brands
+								WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+								AND (lower(country) = lower($2) OR $2 = '')
+								AND (deleted_at IS NULL OR $3)
+								ORDER BY %s %s, id ASC LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(name), country, includeArchived, filters.limit(), filters.offset())
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := int64(0)
 	brands := []*Brand{}
 	for rows.Next() {
 		var brand Brand
 
 		err := rows.Scan(
+			&totalRecords,
 			&brand.ID,
 			&brand.Name,
 			&brand.Country,
 			&brand.Description,
 			&brand.ImageURL,
+			&brand.ImageStorageKey,
+			&brand.Srcset,
+			&brand.DeletedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		brands = append(brands, &brand)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return brands, nil
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return brands, metadata, nil
 }
 
-func (m BrandModel) GetAllBrandNames() (names []string) {
-	sel := "SELECT name FROM brands"
-
-	rows, err := m.DB.Query(sel)
-	if err != nil {
-		log.Fatal(err)
-	}
+// GetStats aggregates the clothes of the brand that are on sale. The price
+// range, sizes and colors are taken over the variants, so price overrides and
+// the sizes and colors of the variants are included.
+func (m BrandModel) GetStats(id int64) (*BrandStats, error) {
+	variants := fmt.Sprintf(`clothes CROSS JOIN LATERAL %s AS variant
+		WHERE clothes.brand_id = $1 AND clothes.deleted_at IS NULL`, clotheVariantSet)
+	query := fmt.Sprintf(`
+		SELECT COUNT(DISTINCT clothes.id), COALESCE(MIN(variant.price), 0), COALESCE(MAX(variant.price), 0),
+		       ARRAY(SELECT DISTINCT unnest(variant.sizes) FROM %[1]s ORDER BY 1),
+		       ARRAY(SELECT DISTINCT variant.color FROM %[1]s ORDER BY 1)
+		FROM %[1]s`, variants)
+	var stats BrandStats
 
-	defer rows.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			log.Fatal(err)
-		}
-		names = append(names, name)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&stats.ProductCount,
+		&stats.PriceMin,
+		&stats.PriceMax,
+		pq.Array(&stats.Sizes),
+		pq.Array(&stats.Colors),
+	)
+	if err != nil {
+		return nil, err
 	}
-	return
+	return &stats, nil
 }
@@ -0,0 +1,524 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownClothe     = errors.New("unknown clothe")
+	ErrBundleUnavailable = errors.New("bundle unavailable")
+)
+
+// Bundle is a set of clothes sold together, e.g. a complete outfit. It is sold
+// either at the fixed Price or at PercentOff off the sum of the prices of its
+// clothes, never at more than the clothes cost on their own.
+type Bundle struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Price       *int64 `json:"price,omitempty"`
+	PercentOff  *int64 `json:"percent_off,omitempty"`
+	// ClotheIDs lists the clothes of the bundle in the order they are shown.
+	ClotheIDs []int64   `json:"-"`
+	Clothes   []*Clothe `json:"clothes"`
+	// RegularPrice is the sum of the prices of the clothes, BundlePrice what
+	// the bundle is sold at.
+	RegularPrice int64  `json:"regular_price"`
+	BundlePrice  int64  `json:"bundle_price"`
+	Currency     string `json:"currency,omitempty"`
+	Version      int    `json:"version"`
+}
+
+// BundleInfo is the part of a bundle embedded into clothe responses.
+type BundleInfo struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func ValidateBundle(v *validator.Validator, bundle *Bundle) {
+	v.Check(bundle.Name != "", "name", "must be provided")
+	v.Check(len(bundle.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(bundle.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+	v.Check(len(bundle.ClotheIDs) >= 2, "clothe_ids", "must contain at least 2 clothes")
+	v.Check(len(bundle.ClotheIDs) <= 10, "clothe_ids", "must not contain more than 10 clothes")
+	v.Check(validator.Unique(bundle.ClotheIDs), "clothe_ids", "must not contain duplicate values")
+	for _, id := range bundle.ClotheIDs {
+		v.Check(id > 0, "clothe_ids", "must contain positive integers")
+	}
+	v.Check((bundle.Price == nil) != (bundle.PercentOff == nil), "price", "must provide either price or percent_off")
+	if bundle.Price != nil {
+		v.Check(*bundle.Price > 0, "price", "must be a positive integer")
+	}
+	if bundle.PercentOff != nil {
+		v.Check(*bundle.PercentOff >= 1 && *bundle.PercentOff <= 90, "percent_off", "must be between 1 and 90")
+	}
+}
+
+// ValidateBundlePurchase checks that sizes, keyed by clothe id and in upper
+// case, gives an available size for every clothe of the bundle and nothing
+// else. variants, keyed by clothe id as well, picks the variant of a clothe to
+// buy, the clothe itself is bought without one. A size is available when the
+// chosen variant is sold in it, so the variants of the clothes must have been
+// loaded.
+func ValidateBundlePurchase(v *validator.Validator, bundle *Bundle, sizes map[int64]string, variants map[int64]int64) {
+	chosen := 0
+	for _, clothe := range bundle.Clothes {
+		size, ok := sizes[clothe.ID]
+		v.Check(ok, "sizes", "must give a size for every clothe of the bundle")
+		variantID, chose := variants[clothe.ID]
+		if chose {
+			chosen++
+		}
+		available, found := clothe.VariantSizes(variantID)
+		v.Check(found, "variants", "must be a variant of the clothe")
+		if ok && found {
+			v.Check(validator.PermittedValue(size, available...), "sizes", "must only give available sizes")
+		}
+	}
+	v.Check(len(sizes) == len(bundle.Clothes), "sizes", "must only give sizes for the clothes of the bundle")
+	v.Check(chosen == len(variants), "variants", "must be a variant of the clothe")
+}
+
+// bundlePrice returns what a bundle whose clothes cost regular in total is
+// sold at.
+func bundlePrice(price, percentOff *int64, regular int64) int64 {
+	switch {
+	case price != nil && *price < regular:
+		return *price
+	case percentOff != nil:
+		return regular - regular*(*percentOff)/100
+	default:
+		return regular
+	}
+}
+
+type BundleModel struct {
+	DB *sql.DB
+}
+
+func (m BundleModel) Insert(bundle *Bundle) error {
+	query := `
+		INSERT INTO bundles (name, description, price, percent_off)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, version`
+	args := []any{bundle.Name, bundle.Description, bundle.Price, bundle.PercentOff}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&bundle.ID, &bundle.Version)
+	if err != nil {
+		return err
+	}
+	err = insertBundleClothes(ctx, tx, bundle)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertBundleClothes adds the clothes of the bundle. ErrUnknownClothe is
+// returned when one of them does not exist or is archived.
+func insertBundleClothes(ctx context.Context, tx *sql.Tx, bundle *Bundle) error {
+	query := `
+		INSERT INTO bundle_clothes (bundle_id, clothe_id, position)
+		SELECT $1, members.clothe_id, members.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS members(clothe_id, position)
+		INNER JOIN clothes ON clothes.id = members.clothe_id
+		WHERE clothes.deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, bundle.ID, pq.Array(bundle.ClotheIDs))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != int64(len(bundle.ClotheIDs)) {
+		return ErrUnknownClothe
+	}
+	return nil
+}
+
+const bundleColumns = `bundles.id, bundles.name, bundles.description, bundles.price, bundles.percent_off, bundles.version,
+		ARRAY(SELECT clothe_id FROM bundle_clothes WHERE bundle_id = bundles.id ORDER BY position)`
+
+func scanBundle(row rowScanner, dest ...any) (*Bundle, error) {
+	var bundle Bundle
+	dest = append(dest,
+		&bundle.ID,
+		&bundle.Name,
+		&bundle.Description,
+		&bundle.Price,
+		&bundle.PercentOff,
+		&bundle.Version,
+		pq.Array(&bundle.ClotheIDs),
+	)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// Get returns the bundle with its clothes, archived ones included.
+func (m BundleModel) Get(id int64) (*Bundle, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT ` + bundleColumns + `
+		FROM bundles
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	bundle, err := scanBundle(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	err = m.setClothes([]*Bundle{bundle})
+	if err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// GetAll lists the bundles whose clothes are all on sale. A clotheID other than
+// 0 only lists the bundles the clothe is part of.
+func (m BundleModel) GetAll(clotheID int64, filters Filters) ([]*Bundle, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM bundles
+		WHERE (EXISTS (SELECT 1 FROM bundle_clothes WHERE bundle_id = bundles.id AND clothe_id = $1) OR $1 = 0)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM bundle_clothes INNER JOIN clothes ON clothes.id = bundle_clothes.clothe_id
+			WHERE bundle_clothes.bundle_id = bundles.id AND clothes.deleted_at IS NOT NULL)
+		ORDER BY %s %s, id ASC LIMIT $2 OFFSET $3`, bundleColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, clotheID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := int64(0)
+	bundles := []*Bundle{}
+	for rows.Next() {
+		bundle, err := scanBundle(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		bundles = append(bundles, bundle)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	err = m.setClothes(bundles)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return bundles, metadata, nil
+}
+
+// setClothes loads the clothes of the bundles and works out their prices. Every
+// bundle gets its own copies of the clothes so that they can be converted
+// independently.
+func (m BundleModel) setClothes(bundles []*Bundle) error {
+	var ids []int64
+	for _, bundle := range bundles {
+		ids = append(ids, bundle.ClotheIDs...)
+	}
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE clothes.id = ANY($1)`, clotheColumns, clotheJoins)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	clothes := map[int64]*Clothe{}
+	for rows.Next() {
+		clothe, err := scanClothe(rows)
+		if err != nil {
+			return err
+		}
+		clothes[clothe.ID] = clothe
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, bundle := range bundles {
+		bundle.Clothes = []*Clothe{}
+		bundle.RegularPrice = 0
+		for _, id := range bundle.ClotheIDs {
+			// Deleted in the meantime, the bundle no longer lists it.
+			if clothes[id] == nil {
+				continue
+			}
+			clothe := *clothes[id]
+			bundle.Clothes = append(bundle.Clothes, &clothe)
+			bundle.RegularPrice += clothe.Price
+		}
+		bundle.BundlePrice = bundlePrice(bundle.Price, bundle.PercentOff, bundle.RegularPrice)
+	}
+	return nil
+}
+
+func (m BundleModel) Update(bundle *Bundle) error {
+	query := `
+		UPDATE bundles
+		SET name = $1, description = $2, price = $3, percent_off = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+	args := []any{bundle.Name, bundle.Description, bundle.Price, bundle.PercentOff, bundle.ID, bundle.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&bundle.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM bundle_clothes WHERE bundle_id = $1`, bundle.ID)
+	if err != nil {
+		return err
+	}
+	err = insertBundleClothes(ctx, tx, bundle)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m BundleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM bundles
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Purchase buys the bundle for the user in the given sizes, keyed by clothe id
+// and in upper case, and returns the amount charged in the currency of the
+// wallet. variants, keyed by clothe id as well, picks the variant of a clothe
+// to buy, which is charged at the price of the variant. The bundle, its clothes
+// and the chosen variants are locked while the purchase runs, so it either sees
+// every clothe on sale in the chosen size and goes through as a whole, or fails
+// with ErrBundleUnavailable without changing anything. ErrNotEnoughMoney is
+// returned when the wallet does not cover the price.
+func (m BundleModel) Purchase(bundleID int64, user *User, sizes map[int64]string, variants map[int64]int64, rates Rates) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + bundleColumns + `
+		FROM bundles
+		WHERE id = $1
+		FOR SHARE`
+	bundle, err := scanBundle(tx.QueryRowContext(ctx, query, bundleID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	// 0 stands for the clothe itself.
+	variantIDs := make([]int64, len(bundle.ClotheIDs))
+	for i, id := range bundle.ClotheIDs {
+		variantIDs[i] = variants[id]
+	}
+	query = `
+		SELECT 1
+		FROM clothe_variants
+		WHERE id = ANY($1)
+		FOR SHARE`
+	_, err = tx.ExecContext(ctx, query, pq.Array(variantIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	// A variant that is not one of the clothe leaves its sizes NULL, so that
+	// no size is available.
+	query = `
+		SELECT clothes.id, COALESCE(clothe_variants.price, clothes.price),
+		       CASE WHEN chosen.variant_id = 0 THEN clothes.sizes ELSE clothe_variants.sizes END
+		FROM unnest($1::bigint[], $2::bigint[]) AS chosen(clothe_id, variant_id)
+		INNER JOIN clothes ON clothes.id = chosen.clothe_id
+		LEFT JOIN clothe_variants ON clothe_variants.id = chosen.variant_id AND clothe_variants.clothe_id = clothes.id
+		WHERE clothes.deleted_at IS NULL
+		FOR SHARE OF clothes`
+	rows, err := tx.QueryContext(ctx, query, pq.Array(bundle.ClotheIDs), pq.Array(variantIDs))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	available := 0
+	for rows.Next() {
+		var id, price int64
+		var clotheSizes []string
+		err := rows.Scan(&id, &price, pq.Array(&clotheSizes))
+		if err != nil {
+			return 0, err
+		}
+		for _, size := range clotheSizes {
+			if strings.ToUpper(size) == sizes[id] {
+				available++
+				break
+			}
+		}
+		bundle.RegularPrice += price
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if available != len(bundle.ClotheIDs) {
+		return 0, ErrBundleUnavailable
+	}
+	bundle.BundlePrice = bundlePrice(bundle.Price, bundle.PercentOff, bundle.RegularPrice)
+	charged, err := rates.Convert(bundle.BundlePrice, rates.Base, user.Currency)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		UPDATE users
+		SET money = money - $1, version = version + 1
+		WHERE id = $2 AND money >= $1
+		RETURNING money, version`
+	err = tx.QueryRowContext(ctx, query, charged, user.ID).Scan(&user.Money, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNotEnoughMoney
+		default:
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE carts SET clothes_id = clothes_id || $1::bigint[] WHERE user_id = $2`,
+		pq.Array(bundle.ClotheIDs), user.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	chosen := make([]string, len(bundle.ClotheIDs))
+	for i, id := range bundle.ClotheIDs {
+		chosen[i] = sizes[id]
+	}
+	query = `
+		INSERT INTO purchase_sizes (user_id, clothe_id, size)
+		SELECT $1, unnest($2::bigint[]), unnest($3::text[])`
+	_, err = tx.ExecContext(ctx, query, user.ID, pq.Array(bundle.ClotheIDs), pq.Array(chosen))
+	if err != nil {
+		return 0, err
+	}
+	return charged, tx.Commit()
+}
+
+// GetAllForClothes returns, keyed by clothe id, the bundles whose clothes are
+// all on sale that the clothes are part of.
+func (m BundleModel) GetAllForClothes(clotheIDs []int64) (map[int64][]BundleInfo, error) {
+	query := `
+		SELECT bundle_clothes.clothe_id, bundles.id, bundles.name
+		FROM bundles INNER JOIN bundle_clothes ON bundle_clothes.bundle_id = bundles.id
+		WHERE bundle_clothes.clothe_id = ANY($1)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM bundle_clothes AS members INNER JOIN clothes ON clothes.id = members.clothe_id
+			WHERE members.bundle_id = bundles.id AND clothes.deleted_at IS NOT NULL)
+		ORDER BY bundles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(clotheIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bundles := make(map[int64][]BundleInfo)
+	for rows.Next() {
+		var clotheID int64
+		var bundle BundleInfo
+		err := rows.Scan(&clotheID, &bundle.ID, &bundle.Name)
+		if err != nil {
+			return nil, err
+		}
+		bundles[clotheID] = append(bundles[clotheID], bundle)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return bundles, nil
+}
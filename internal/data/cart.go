@@ -28,6 +28,47 @@ func (m CartsModel) AddClotheForCart(userID int64, clothe Clothe) error {
 	return err
 }
 
+// Purchase charges the user price, in the currency of the wallet, and adds the
+// clothe to the cart in one transaction. A non-empty size is recorded for size
+// recommendations. ErrNotEnoughMoney is returned when the wallet falls short.
+func (m CartsModel) Purchase(user *User, clotheID int64, price int64, size string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET money = money - $1, version = version + 1
+		WHERE id = $2 AND money >= $1
+		RETURNING money, version`
+	err = tx.QueryRowContext(ctx, query, price, user.ID).Scan(&user.Money, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotEnoughMoney
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE carts SET clothes_id = array_append(clothes_id, $1) WHERE user_id = $2`, clotheID, user.ID)
+	if err != nil {
+		return err
+	}
+	if size != "" {
+		_, err = tx.ExecContext(ctx, `INSERT INTO purchase_sizes (user_id, clothe_id, size) VALUES ($1, $2, $3)`, user.ID, clotheID, size)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (m CartsModel) CreateCartForUser(userID int64) error {
 	query := `
 INSERT INTO carts VALUES ($1)`
@@ -37,6 +78,17 @@ INSERT INTO carts VALUES ($1)`
 	return err
 }
 
+// Contains reports whether the user has bought the clothe.
+func (m CartsModel) Contains(userID, clotheID int64) (bool, error) {
+	query := `
+				SELECT EXISTS (SELECT 1 FROM carts WHERE user_id = $1 AND $2 = ANY(clothes_id))`
+	var contains bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID, clotheID).Scan(&contains)
+	return contains, err
+}
+
 func (m CartsModel) GetById(id int64) ([]int64, error) {
 	query := `
 				SELECT clothes_id
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var (
+	SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+	ErrDuplicateSlug  = errors.New("duplicate slug")
+	ErrCategoryInUse  = errors.New("category in use")
+	ErrCategoryCyclic = errors.New("category cycle")
+)
+
+type Category struct {
+	ID       int64  `json:"id"`
+	ParentID *int64 `json:"parent_id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+}
+
+// CategoryInfo is the part of a category embedded into clothe responses.
+type CategoryInfo struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(len(category.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(category.Slug != "", "slug", "must be provided")
+	v.Check(validator.Matches(category.Slug, SlugRX), "slug", "must contain only lowercase letters, digits and dashes")
+	// The root categories are the sexes clothes are listed by.
+	if category.ParentID == nil {
+		v.Check(validator.PermittedValue(category.Slug, SexSafelist...), "slug", "must be one of men, women or unisex for a root category")
+	}
+	if category.ParentID != nil {
+		v.Check(*category.ParentID > 0, "parent_id", "must be a positive integer")
+		v.Check(*category.ParentID != category.ID, "parent_id", "must not be the category itself")
+	}
+}
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+func (m CategoryModel) Insert(category *Category) error {
+	query := `
+INSERT INTO categories (parent_id, name, slug)
+VALUES ($1, $2, $3)
+RETURNING id`
+	args := []any{category.ParentID, category.Name, category.Slug}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CategoryModel) Get(id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, parent_id, name, slug
+FROM categories
+WHERE id = $1`
+	var category Category
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&category.ID,
+		&category.ParentID,
+		&category.Name,
+		&category.Slug,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &category, nil
+}
+
+func (m CategoryModel) GetBySlug(slug string) (*Category, error) {
+	query := `
+SELECT id, parent_id, name, slug
+FROM categories
+WHERE slug = $1`
+	var category Category
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(
+		&category.ID,
+		&category.ParentID,
+		&category.Name,
+		&category.Slug,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &category, nil
+}
+
+func (m CategoryModel) GetAll(filters Filters) ([]*Category, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, parent_id, name, slug
+FROM categories
+ORDER BY %s %s, id ASC
+LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := int64(0)
+	categories := []*Category{}
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(
+			&totalRecords,
+			&category.ID,
+			&category.ParentID,
+			&category.Name,
+			&category.Slug,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		categories = append(categories, &category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return categories, metadata, nil
+}
+
+// Update saves the category. Moving a category underneath one of its own
+// descendants would detach that part of the tree, so ErrCategoryCyclic is
+// returned in that case.
+func (m CategoryModel) Update(category *Category) error {
+	query := `
+UPDATE categories
+SET parent_id = $1, name = $2, slug = $3
+WHERE id = $4
+AND NOT EXISTS (
+    WITH RECURSIVE descendants AS (
+        SELECT id FROM categories WHERE id = $4
+        UNION ALL
+        SELECT categories.id FROM categories INNER JOIN descendants ON categories.parent_id = descendants.id
+    )
+    SELECT 1 FROM descendants WHERE descendants.id = $1
+)
+RETURNING id`
+	args := []any{category.ParentID, category.Name, category.Slug, category.ID}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_key"`:
+			return ErrDuplicateSlug
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrCategoryCyclic
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a category that has neither subcategories nor clothes,
+// otherwise ErrCategoryInUse is returned.
+func (m CategoryModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM categories
+WHERE id = $1
+AND NOT EXISTS (SELECT 1 FROM categories children WHERE children.parent_id = categories.id)
+AND NOT EXISTS (SELECT 1 FROM clothes WHERE clothes.category_id = categories.id)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		_, err := m.Get(id)
+		if err != nil {
+			return err
+		}
+		return ErrCategoryInUse
+	}
+	return nil
+}
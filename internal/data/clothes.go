@@ -7,71 +7,199 @@ import (
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Clothe struct {
-	ID       int64    `json:"id"`
-	Name     string   `json:"name"`
-	Price    int64    `json:"price"`
-	Brand    string   `json:"brand"`
-	Color    string   `json:"color"`
-	Sizes    []string `json:"sizes"`
-	Sex      string   `json:"sex,omitempty"`
-	Type     string   `json:"type,omitempty"`
-	ImageURL string   `json:"image_url,omitempty"`
-}
-
-func ValidateClothe(v *validator.Validator, clothe *Clothe) {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Price int64  `json:"price"`
+	// Currency is the currency of Price and of the variant prices, it is set
+	// when the prices are converted for display.
+	Currency   string       `json:"currency,omitempty"`
+	BrandID    int64        `json:"-"`
+	Brand      BrandInfo    `json:"brand"`
+	Color      string       `json:"color"`
+	Sizes      []string     `json:"sizes"`
+	Sex        string       `json:"sex,omitempty"`
+	CategoryID int64        `json:"-"`
+	Category   CategoryInfo `json:"category"`
+	// SizeSystemID is the size system Sizes are taken from, SizeSystem its
+	// slug.
+	SizeSystemID int64  `json:"-"`
+	SizeSystem   string `json:"size_system"`
+	ImageURL     string `json:"image_url,omitempty"`
+	// Srcset holds the resized variants of the primary image.
+	Srcset      Srcset `json:"srcset,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Tags are free-form labels, stored lowercase.
+	Tags   []string `json:"tags"`
+	Rating Rating   `json:"rating"`
+	// Images is only filled in for a single clothe, images of a variant are
+	// listed with the variant instead.
+	Images   []ClotheImage   `json:"images,omitempty"`
+	Variants []ClotheVariant `json:"variants,omitempty"`
+	// Bundles lists the bundles on sale the clothe is part of, it is only
+	// filled in for the clothes listing.
+	Bundles   []BundleInfo `json:"bundles,omitempty"`
+	DeletedAt *time.Time   `json:"deleted_at,omitempty"`
+}
+
+// SexSafelist lists the sexes clothes are made for. Each of them is also the
+// slug of a root category, see ValidateCategory.
+var SexSafelist = []string{"men", "women", "unisex"}
+
+// ValidateClothe checks the clothe; system is the size system of the clothe,
+// nil when it does not exist. Whether the category exists is checked on
+// lookup.
+func ValidateClothe(v *validator.Validator, clothe *Clothe, system *SizeSystem) {
 	v.Check(clothe.Name != "", "name", "must be provided")
-	v.Check(clothe.Brand != "", "brand", "must be provided")
+	v.Check(clothe.BrandID != 0, "brand_id", "must be provided")
+	v.Check(clothe.BrandID >= 0, "brand_id", "must be a positive integer")
 	v.Check(clothe.Color != "", "color", "must be provided")
 	v.Check(clothe.Sex != "", "sex", "must be provided")
-	v.Check(clothe.Type != "", "type", "must be provided")
-	v.Check(clothe.ImageURL != "", "image_url", "must be provided")
+	v.Check(validator.PermittedValue(clothe.Sex, SexSafelist...), "sex", "must be one of men, women or unisex")
+	v.Check(clothe.CategoryID != 0, "category_id", "must be provided")
 	v.Check(len(clothe.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(clothe.Description) <= 5000, "description", "must not be more than 5000 bytes long")
 	v.Check(clothe.Price != 0, "price", "must be provided")
 	v.Check(clothe.Price > 0, "price", "must be a positive integer")
 	v.Check(clothe.Sizes != nil, "sizes", "must be provided")
 	v.Check(len(clothe.Sizes) >= 1, "sizes", "must contain at least 1 size")
 	v.Check(validator.Unique(clothe.Sizes), "sizes", "must not contain duplicate values")
+	v.Check(system != nil, "size_system_id", "unknown size system")
+	if system != nil {
+		validateSizes(v, clothe.Sizes, system)
+	}
+	validateTags(v, clothe.Tags)
 }
 
-type ClotheModel struct {
-	DB *sql.DB
+// validateTags checks the tags as NormalizeTags would store them.
+func validateTags(v *validator.Validator, tags []string) {
+	tags = NormalizeTags(tags)
+	v.Check(len(tags) <= 20, "tags", "must not contain more than 20 tags")
+	v.Check(validator.Unique(tags), "tags", "must not contain duplicate values")
+	for _, tag := range tags {
+		v.Check(tag != "", "tags", "must not contain empty tags")
+		v.Check(len(tag) <= 50, "tags", "must not contain tags more than 50 bytes long")
+	}
 }
 
-func (m ClotheModel) Insert(clothe *Clothe) error {
-	query := `INSERT INTO clothes (name, price, brand, color, sizes, sex, type, image_url)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-				RETURNING id`
-	args := []any{clothe.Name, clothe.Price, clothe.Brand, clothe.Color, pq.Array(clothe.Sizes),
-		clothe.Sex, clothe.Type, clothe.ImageURL}
-	return m.DB.QueryRow(query, args...).Scan(&clothe.ID)
+// NormalizeTags trims and lowercases the tags, so that filtering by tag does
+// not depend on how they were typed.
+func NormalizeTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return lowerAll(tags)
 }
 
-func (m ClotheModel) Get(id int64) (*Clothe, error) {
-	if id < 1 {
-		return nil, ErrRecordNotFound
+// validateSizes checks that every size belongs to the size system.
+func validateSizes(v *validator.Validator, sizes []string, system *SizeSystem) {
+	for _, size := range sizes {
+		v.Check(system.Permits(size), "sizes", "must only contain sizes of the size system of the clothe")
 	}
-	query := `
-		SELECT *
-		FROM clothes
-		WHERE id = $1`
-	var clothe Clothe
+}
+
+// clotheColumns lists the columns scanned by scanClothe. The brand columns are
+// aliased so that sorting by "brand" orders by the brand name.
+const clotheColumns = `clothes.id, clothes.name, clothes.price, clothes.brand_id, brands.name AS brand,
+		brands.country AS brand_country, brands.image_url AS brand_image_url, clothes.color, clothes.sizes,
+		clothes.sex, clothes.category_id, categories.name AS category, categories.slug AS category_slug,
+		clothes.size_system_id, size_systems.slug AS size_system,
+		clothes.image_url, COALESCE(primary_image.variants, '{}') AS srcset, clothes.description,
+		clothes.tags, clothes.rating_average AS rating, clothes.rating_count, clothes.deleted_at`
+
+const clotheJoins = `clothes INNER JOIN brands ON brands.id = clothes.brand_id
+		INNER JOIN categories ON categories.id = clothes.category_id
+		INNER JOIN size_systems ON size_systems.id = clothes.size_system_id
+		LEFT JOIN clothe_images primary_image ON primary_image.clothe_id = clothes.id AND primary_image.is_primary`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// countingScanner reads the window count that precedes the regular columns of
+// a paginated query.
+type countingScanner struct {
+	rows  *sql.Rows
+	total *int64
+}
+
+func (s countingScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append([]any{s.total}, dest...)...)
+}
 
-	err := m.DB.QueryRow(query, id).Scan(
+func scanClothe(row rowScanner) (*Clothe, error) {
+	var clothe Clothe
+	err := row.Scan(
 		&clothe.ID,
 		&clothe.Name,
 		&clothe.Price,
-		&clothe.Brand,
+		&clothe.BrandID,
+		&clothe.Brand.Name,
+		&clothe.Brand.Country,
+		&clothe.Brand.ImageURL,
 		&clothe.Color,
 		pq.Array(&clothe.Sizes),
 		&clothe.Sex,
-		&clothe.Type,
+		&clothe.CategoryID,
+		&clothe.Category.Name,
+		&clothe.Category.Slug,
+		&clothe.SizeSystemID,
+		&clothe.SizeSystem,
 		&clothe.ImageURL,
+		&clothe.Srcset,
+		&clothe.Description,
+		pq.Array(&clothe.Tags),
+		&clothe.Rating.Average,
+		&clothe.Rating.Count,
+		&clothe.DeletedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	clothe.Brand.ID = clothe.BrandID
+	clothe.Category.ID = clothe.CategoryID
+	return &clothe, nil
+}
+
+type ClotheModel struct {
+	DB *sql.DB
+}
+
+func (m ClotheModel) Insert(clothe *Clothe) error {
+	// A zero SizeSystemID stands for the default size system.
+	query := `INSERT INTO clothes (name, price, brand_id, color, sizes, sex, category_id, image_url, description, size_system_id, tags)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9,
+				        COALESCE(NULLIF($10::bigint, 0), (SELECT id FROM size_systems WHERE slug = $11)), $12)
+				RETURNING id, size_system_id`
+	args := []any{clothe.Name, clothe.Price, clothe.BrandID, clothe.Color, pq.Array(clothe.Sizes),
+		clothe.Sex, clothe.CategoryID, clothe.ImageURL, clothe.Description, clothe.SizeSystemID, DefaultSizeSystem, pq.Array(NormalizeTags(clothe.Tags))}
+	return m.DB.QueryRow(query, args...).Scan(&clothe.ID, &clothe.SizeSystemID)
+}
+
+func (m ClotheModel) Get(id int64) (*Clothe, error) {
+	return m.get(id, false)
+}
+
+func (m ClotheModel) GetIncludingArchived(id int64) (*Clothe, error) {
+	return m.get(id, true)
+}
+
+func (m ClotheModel) get(id int64, includeArchived bool) (*Clothe, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE clothes.id = $1 AND (clothes.deleted_at IS NULL OR $2)`, clotheColumns, clotheJoins)
+
+	clothe, err := scanClothe(m.DB.QueryRow(query, id, includeArchived))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -80,37 +208,78 @@ func (m ClotheModel) Get(id int64) (*Clothe, error) {
 			return nil, err
 		}
 	}
-	return &clothe, nil
+	return clothe, nil
+}
+
+// GetByIDs also returns archived clothes, so that carts keep resolving items
+// that are no longer on sale.
+func (m ClotheModel) GetByIDs(ids []int64) ([]*Clothe, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE clothes.id = ANY($1)
+		ORDER BY clothes.id`, clotheColumns, clotheJoins)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clothes := []*Clothe{}
+	for rows.Next() {
+		clothe, err := scanClothe(rows)
+		if err != nil {
+			return nil, err
+		}
+		clothes = append(clothes, clothe)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clothes, nil
 }
 
 func (m ClotheModel) Update(clothe *Clothe) error {
 	query := `
 			UPDATE clothes
-			SET name = $1, price = $2, brand = $3, color = $4, sizes = $5, 
-			    sex = $6, type = $7, image_url = $8 
-			WHERE id = $9
+			SET name = $1, price = $2, brand_id = $3, color = $4, sizes = $5, 
+			    sex = $6, category_id = $7, image_url = $8, description = $9, size_system_id = $10,
+			    tags = $11
+			WHERE id = $12 AND deleted_at IS NULL
 			RETURNING id`
 	args := []any{
 		clothe.Name,
 		clothe.Price,
-		clothe.Brand,
+		clothe.BrandID,
 		clothe.Color,
 		pq.Array(clothe.Sizes),
 		clothe.Sex,
-		clothe.Type,
+		clothe.CategoryID,
 		clothe.ImageURL,
+		clothe.Description,
+		clothe.SizeSystemID,
+		pq.Array(NormalizeTags(clothe.Tags)),
 		clothe.ID,
 	}
 	return m.DB.QueryRow(query, args...).Scan(&clothe.ID)
 }
 
+// Delete archives the clothe instead of removing the row, so carts that still
+// hold its id keep pointing at something.
 func (m ClotheModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `
-				DELETE FROM clothes
-				WHERE id = $1`
+				UPDATE clothes
+				SET deleted_at = NOW()
+				WHERE id = $1 AND deleted_at IS NULL`
 	result, err := m.DB.Exec(query, id)
 	if err != nil {
 		return err
@@ -125,68 +294,328 @@ func (m ClotheModel) Delete(id int64) error {
 	return nil
 }
 
-func (m ClotheModel) GetAll(name string, brand string, priceMax int64, priceMin int64,
-	sizes []string, color string, type_ string, sex string,
-	filters Filters) ([]*Clothe, error) {
-	sizesUpper := []string{}
+func (m ClotheModel) Restore(id int64) (*Clothe, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+				UPDATE clothes
+				SET deleted_at = NULL
+				WHERE id = $1 AND deleted_at IS NOT NULL`
+	result, err := m.DB.Exec(query, id)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return m.Get(id)
+}
+
+// ClotheQuery holds the filters of a clothes listing. Zero values mean the
+// filter is not set.
+type ClotheQuery struct {
+	// Search is matched against the name, brand, color and description,
+	// tolerating typos and unfinished words.
+	Search   string
+	Brands   []string
+	BrandID  int64
+	PriceMax int64
+	PriceMin int64
+	Sizes    []string
+	// SizesMatch is "all" to require every size in Sizes, or "any" to require
+	// at least one of them.
+	SizesMatch      string
+	Colors          []string
+	CategoryID      int64
+	SizeSystemID    int64
+	Sex             string
+	RatingMin       float64
+	IncludeArchived bool
+	// Tags requires every one of the tags to be set on the clothe.
+	Tags []string
+	// CollectionID restricts the listing to the members of a collection, it
+	// also enables the "position" sort.
+	CollectionID int64
+}
+
+var SizesMatchSafelist = []string{"all", "any"}
+
+// where builds the WHERE clause for the query together with its arguments. The
+// filter named by skip is left out, which lets facet counts ignore their own
+// filter.
+func (q ClotheQuery) where(skip string) (string, []any) {
+	return q.conditions(skip, false)
+}
+
+// variantWhere is where for queries that join every variant of the clothes as
+// variant, see clotheVariantSet. The color, sizes and price filters apply to
+// the joined variant itself, so that facets over the variants only count the
+// values of the variants that match.
+func (q ClotheQuery) variantWhere(skip string) (string, []any) {
+	return q.conditions(skip, true)
+}
 
-	for i := 0; i < len(sizes); i++ {
-		sizesUpper = append(sizesUpper, strings.ToUpper(sizes[i]))
+func (q ClotheQuery) conditions(skip string, joined bool) (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
 	}
-	query := fmt.Sprintf(`
-								SELECT *
-								FROM clothes
-								WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
-								AND (clothes.sizes @> $2 OR $2 = '{}')
-								AND price < $3 AND price > $4
-								AND lower(brand) = lower($5)
-								ORDER BY %s %s, id ASC LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
-
-	if brand == "" {
-		query = fmt.Sprintf(`
-								SELECT *
-								FROM clothes
-								WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
-								AND (clothes.sizes @> $2 OR $2 = '{}')
-								AND price < $3 AND price > $4
-								AND lower(brand) != lower($5)
-								ORDER BY %s %s, id ASC LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+
+	if q.Search != "" {
+		search, prefix := arg(q.Search), arg(prefixQuery(q.Search))
+		conditions = append(conditions, fmt.Sprintf(`(%s @@ to_tsquery('simple', %s)
+									OR to_tsvector('simple', brands.name) @@ to_tsquery('simple', %s)
+									OR %s <%% clothes.name OR %s <%% brands.name OR %s <%% clothes.color)`,
+			clotheSearchDocument, prefix, prefix, search, search, search))
+	}
+
+	// Color, sizes and price belong to the variants, a clothe matches when one
+	// of its variants matches all of them.
+	variantConditions := []string{}
+	if len(q.Sizes) > 0 && skip != "sizes" {
+		sizesUpper := []string{}
+		for i := 0; i < len(q.Sizes); i++ {
+			sizesUpper = append(sizesUpper, strings.ToUpper(q.Sizes[i]))
+		}
+		operator := "@>"
+		if q.SizesMatch == "any" {
+			operator = "&&"
+		}
+		variantConditions = append(variantConditions, fmt.Sprintf("variant.sizes %s %s", operator, arg(pq.Array(sizesUpper))))
+	}
+	if q.PriceMin > 0 && skip != "price" {
+		variantConditions = append(variantConditions, fmt.Sprintf("variant.price >= %s", arg(q.PriceMin)))
+	}
+	if q.PriceMax > 0 && skip != "price" {
+		variantConditions = append(variantConditions, fmt.Sprintf("variant.price <= %s", arg(q.PriceMax)))
+	}
+	if len(q.Colors) > 0 && skip != "color" {
+		variantConditions = append(variantConditions, fmt.Sprintf("lower(variant.color) = ANY(%s)", arg(pq.Array(lowerAll(q.Colors)))))
+	}
+	switch {
+	case joined:
+		conditions = append(conditions, variantConditions...)
+	case len(variantConditions) > 0:
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM %s AS variant WHERE %s)",
+			clotheVariantSet, strings.Join(variantConditions, " AND ")))
+	}
+	if !q.IncludeArchived {
+		conditions = append(conditions, "clothes.deleted_at IS NULL")
+	}
+	if len(q.Brands) > 0 && skip != "brand" {
+		conditions = append(conditions, fmt.Sprintf("lower(brands.name) = ANY(%s)", arg(pq.Array(lowerAll(q.Brands)))))
+	}
+	if q.Sex != "" && skip != "sex" {
+		conditions = append(conditions, fmt.Sprintf("clothes.sex = %s", arg(strings.ToLower(q.Sex))))
+	}
+	if q.RatingMin > 0 && skip != "rating" {
+		conditions = append(conditions, fmt.Sprintf("clothes.rating_average >= %s", arg(q.RatingMin)))
+	}
+	if q.SizeSystemID != 0 {
+		conditions = append(conditions, fmt.Sprintf("clothes.size_system_id = %s", arg(q.SizeSystemID)))
+	}
+	if len(q.Tags) > 0 {
+		conditions = append(conditions, fmt.Sprintf("clothes.tags @> %s", arg(pq.Array(NormalizeTags(q.Tags)))))
+	}
+	if q.CollectionID != 0 {
+		conditions = append(conditions, fmt.Sprintf(
+			"clothes.id IN (SELECT clothe_id FROM collection_clothes WHERE collection_id = %s)", arg(q.CollectionID)))
+	}
+	if q.BrandID != 0 {
+		conditions = append(conditions, fmt.Sprintf("clothes.brand_id = %s", arg(q.BrandID)))
 	}
+	if q.CategoryID != 0 && skip != "category" {
+		conditions = append(conditions, fmt.Sprintf(`clothes.category_id IN (
+			WITH RECURSIVE descendants AS (
+				SELECT id FROM categories WHERE id = %s
+				UNION ALL
+				SELECT categories.id FROM categories
+				INNER JOIN descendants ON categories.parent_id = descendants.id
+			)
+			SELECT id FROM descendants)`, arg(q.CategoryID)))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, "\n\t\t\t\t\t\t\t\tAND "), args
+}
+
+// clotheVariantSet lists every variant of a clothe, the default one described by
+// the clothe itself included, with the price overrides applied.
+const clotheVariantSet = `(SELECT clothes.color, clothes.sizes, clothes.price
+		UNION ALL
+		SELECT clothe_variants.color, clothe_variants.sizes, COALESCE(clothe_variants.price, clothes.price)
+		FROM clothe_variants WHERE clothe_variants.clothe_id = clothes.id)`
+
+// clotheSearchDocument must stay in sync with the clothes_search_idx index.
+const clotheSearchDocument = `to_tsvector('simple', clothes.name || ' ' || clothes.color || ' ' || clothes.description)`
+
+var searchWordRX = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// prefixQuery turns free text into a tsquery in which every word may be the
+// beginning of a longer one, e.g. "hood jack" becomes "hood:* & jack:*".
+func prefixQuery(search string) string {
+	words := searchWordRX.FindAllString(strings.ToLower(search), -1)
+	for i := range words {
+		words[i] += ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// clotheSortColumns maps the sort keys of the clothes listing onto the
+// expressions they order by, for use in keyset conditions.
+var clotheSortColumns = map[string]string{
+	"id":     "clothes.id",
+	"name":   "clothes.name",
+	"price":  "clothes.price",
+	"sex":    "clothes.sex",
+	"brand":  "brands.name",
+	"rating": "clothes.rating_average",
+}
+
+func (c *Clothe) sortValue(column string) string {
+	switch column {
+	case "name":
+		return c.Name
+	case "price":
+		return strconv.FormatInt(c.Price, 10)
+	case "sex":
+		return c.Sex
+	case "brand":
+		return c.Brand.Name
+	case "rating":
+		return strconv.FormatFloat(c.Rating.Average, 'f', 2, 64)
+	default:
+		return strconv.FormatInt(c.ID, 10)
+	}
+}
+
+// VariantSizes lists the sizes, in upper case, of the variant of the clothe, or
+// of the clothe itself when variantID is 0. The variants must have been
+// loaded, false is returned when the clothe has no such variant.
+func (c *Clothe) VariantSizes(variantID int64) ([]string, bool) {
+	sizes := c.Sizes
+	if variantID != 0 {
+		found := false
+		for _, variant := range c.Variants {
+			if variant.ID == variantID {
+				sizes, found = variant.Sizes, true
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	upper := make([]string, len(sizes))
+	for i, size := range sizes {
+		upper[i] = strings.ToUpper(size)
+	}
+	return upper, true
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i := range values {
+		lowered[i] = strings.ToLower(strings.TrimSpace(values[i]))
+	}
+	return lowered
+}
+
+func (m ClotheModel) GetAll(q ClotheQuery, filters Filters) ([]*Clothe, Metadata, error) {
+	where, args := q.where("")
+	if filters.Cursor != "" {
+		c, ok := decodeCursor(filters.Cursor)
+		if !ok {
+			return nil, Metadata{}, errors.New("invalid cursor")
+		}
+		// Rows are ordered by the sort column and then by id ascending, so the
+		// next page starts after the cursor row in that order.
+		column := clotheSortColumns[filters.sortColumn()]
+		operator := ">"
+		if filters.sortDirection() == "DESC" {
+			operator = "<"
+		}
+		args = append(args, c.Value, c.ID)
+		condition := fmt.Sprintf("(%s %s $%d OR (%s = $%d AND clothes.id > $%d))",
+			column, operator, len(args)-1, column, len(args)-1, len(args))
+		if where == "" {
+			where = "WHERE " + condition
+		} else {
+			where += "\n\t\t\t\t\t\t\t\tAND " + condition
+		}
+	}
+	orderBy, direction := filters.sortColumn(), filters.sortDirection()
+	if orderBy == "relevance" {
+		// Full-text rank and trigram similarity are on different scales, adding
+		// them up favours rows that score well on both. The best matches come
+		// first.
+		direction = "DESC"
+		args = append(args, q.Search, prefixQuery(q.Search))
+		orderBy = fmt.Sprintf(`ts_rank(%s || to_tsvector('simple', brands.name), to_tsquery('simple', $%d))
+									+ GREATEST(word_similarity($%d, clothes.name), word_similarity($%d, brands.name))`,
+			clotheSearchDocument, len(args), len(args)-1, len(args)-1)
+	}
+	if orderBy == "position" {
+		args = append(args, q.CollectionID)
+		orderBy = fmt.Sprintf(`(SELECT position FROM collection_clothes
+									WHERE collection_id = $%d AND clothe_id = clothes.id)`, len(args))
+	}
+	query := fmt.Sprintf(`
+								SELECT count(*) OVER(), %s
+								FROM %s
+								%s
+								ORDER BY %s %s, id ASC LIMIT $%d OFFSET $%d`, clotheColumns, clotheJoins, where,
+		orderBy, direction, len(args)+1, len(args)+2)
+	args = append(args, filters.limit(), filters.offset())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, name, pq.Array(sizesUpper), priceMax, priceMin, brand, filters.limit(),
-		filters.offset())
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := int64(0)
 	clothes := []*Clothe{}
 	for rows.Next() {
-		var clothe Clothe
-
-		err := rows.Scan(
-			&clothe.ID,
-			&clothe.Name,
-			&clothe.Price,
-			&clothe.Brand,
-			&clothe.Color,
-			pq.Array(&clothe.Sizes),
-			&clothe.Sex,
-			&clothe.Type,
-			&clothe.ImageURL,
-		)
+		clothe, err := scanClothe(countingScanner{rows: rows, total: &totalRecords})
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
-		clothes = append(clothes, &clothe)
+		clothes = append(clothes, clothe)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return clothes, nil
+	// In cursor mode the count only covers the rows after the cursor, so page
+	// numbers would be meaningless there.
+	var metadata Metadata
+	if filters.Cursor == "" {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	} else {
+		metadata = Metadata{PageSize: filters.PageSize}
+	}
+	// Relevance depends on the search term and position on the collection,
+	// neither can be used as a keyset.
+	if totalRecords > filters.offset()+int64(len(clothes)) && clotheSortColumns[filters.sortColumn()] != "" {
+		last := clothes[len(clothes)-1]
+		metadata.NextCursor = encodeCursor(cursor{
+			Sort:  filters.Sort,
+			Value: last.sortValue(filters.sortColumn()),
+			ID:    last.ID,
+		})
+	}
+	return clothes, metadata, nil
 }
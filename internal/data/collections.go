@@ -0,0 +1,308 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Collection is a curated list of clothes, e.g. "Summer 2026". It is only
+// shown between StartsAt and EndsAt, a nil bound leaves that side open.
+type Collection struct {
+	ID           int64  `json:"id"`
+	Slug         string `json:"slug"`
+	Name         string `json:"name"`
+	Description  string `json:"description,omitempty"`
+	HeroImageURL string `json:"hero_image_url,omitempty"`
+	// HeroImageStorageKey is set when the hero image was uploaded rather
+	// than linked.
+	HeroImageStorageKey string     `json:"-"`
+	HeroSrcset          Srcset     `json:"hero_srcset,omitempty"`
+	StartsAt            *time.Time `json:"starts_at,omitempty"`
+	EndsAt              *time.Time `json:"ends_at,omitempty"`
+	Version             int32      `json:"version"`
+}
+
+// Active reports whether the collection is shown at the time now.
+func (c *Collection) Active(now time.Time) bool {
+	return (c.StartsAt == nil || !now.Before(*c.StartsAt)) && (c.EndsAt == nil || now.Before(*c.EndsAt))
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(collection.Slug != "", "slug", "must be provided")
+	v.Check(validator.Matches(collection.Slug, SlugRX), "slug", "must contain only lowercase letters, digits and dashes")
+	v.Check(len(collection.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+	if collection.StartsAt != nil && collection.EndsAt != nil {
+		v.Check(collection.StartsAt.Before(*collection.EndsAt), "ends_at", "must be after starts_at")
+	}
+}
+
+// ValidateCollectionClothes checks the clothes of a collection, listed in the
+// order they are shown in.
+func ValidateCollectionClothes(v *validator.Validator, clotheIDs []int64) {
+	v.Check(clotheIDs != nil, "clothe_ids", "must be provided")
+	v.Check(len(clotheIDs) <= 500, "clothe_ids", "must not contain more than 500 clothes")
+	v.Check(validator.Unique(clotheIDs), "clothe_ids", "must not contain duplicate values")
+	for _, id := range clotheIDs {
+		v.Check(id > 0, "clothe_ids", "must only contain positive integers")
+	}
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+const collectionColumns = `id, slug, name, description, hero_image_url, hero_image_storage_key,
+		hero_image_variants, starts_at, ends_at, version`
+
+// scanCollection scans the collection columns, preceded by dest.
+func scanCollection(row rowScanner, dest ...any) (*Collection, error) {
+	var collection Collection
+	err := row.Scan(append(dest,
+		&collection.ID,
+		&collection.Slug,
+		&collection.Name,
+		&collection.Description,
+		&collection.HeroImageURL,
+		&collection.HeroImageStorageKey,
+		&collection.HeroSrcset,
+		&collection.StartsAt,
+		&collection.EndsAt,
+		&collection.Version,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `
+		INSERT INTO collections (slug, name, description, hero_image_url, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, version`
+	args := []any{collection.Slug, collection.Name, collection.Description, collection.HeroImageURL,
+		collection.StartsAt, collection.EndsAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "collections_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	return m.get("id = $1", id)
+}
+
+func (m CollectionModel) GetBySlug(slug string) (*Collection, error) {
+	return m.get("slug = $1", slug)
+}
+
+func (m CollectionModel) get(condition string, arg any) (*Collection, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM collections
+		WHERE %s`, collectionColumns, condition)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	collection, err := scanCollection(m.DB.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return collection, nil
+}
+
+// GetAll lists the collections that are shown right now, or every collection
+// when includeInactive is set.
+func (m CollectionModel) GetAll(includeInactive bool, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM collections
+		WHERE $1 OR ((starts_at IS NULL OR starts_at <= NOW()) AND (ends_at IS NULL OR ends_at > NOW()))
+		ORDER BY %s %s, id ASC LIMIT $2 OFFSET $3`, collectionColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, includeInactive, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := int64(0)
+	collections := []*Collection{}
+	for rows.Next() {
+		collection, err := scanCollection(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		collections = append(collections, collection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return collections, metadata, nil
+}
+
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `
+		UPDATE collections
+		SET slug = $1, name = $2, description = $3, hero_image_url = $4, hero_image_storage_key = $5,
+		    hero_image_variants = $6, starts_at = $7, ends_at = $8, version = version + 1
+		WHERE id = $9 AND version = $10
+		RETURNING version`
+	args := []any{
+		collection.Slug,
+		collection.Name,
+		collection.Description,
+		collection.HeroImageURL,
+		collection.HeroImageStorageKey,
+		collection.HeroSrcset,
+		collection.StartsAt,
+		collection.EndsAt,
+		collection.ID,
+		collection.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "collections_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// SetHeroImageVariants records the resized variants of an uploaded hero
+// image. ErrRecordNotFound is returned when the collection has got another
+// image in the meantime.
+func (m CollectionModel) SetHeroImageVariants(id int64, storageKey string, srcset Srcset) error {
+	query := `
+		UPDATE collections
+		SET hero_image_variants = $1
+		WHERE id = $2 AND hero_image_storage_key = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, srcset, id, storageKey)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetClothes replaces the clothes of the collection, which are shown in the
+// order of clotheIDs. ErrUnknownClothe is returned when one of them does not
+// exist or is archived.
+func (m CollectionModel) SetClothes(id int64, clotheIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM collections WHERE id = $1 FOR UPDATE`, id).Scan(&locked)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM collection_clothes WHERE collection_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO collection_clothes (collection_id, clothe_id, position)
+		SELECT $1, members.clothe_id, members.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS members(clothe_id, position)
+		INNER JOIN clothes ON clothes.id = members.clothe_id
+		WHERE clothes.deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, id, pq.Array(clotheIDs))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != int64(len(clotheIDs)) {
+		return ErrUnknownClothe
+	}
+	return tx.Commit()
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM collections
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	CurrencyRX = regexp.MustCompile("^[A-Z]{3}$")
+
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrBaseCurrency        = errors.New("base currency")
+	ErrCurrencyInUse       = errors.New("currency in use")
+)
+
+// ExchangeRate is the amount of Currency that one unit of the base currency
+// buys. Rate is a decimal string so that no precision is lost on the way from
+// and to the database.
+type ExchangeRate struct {
+	Currency   string    `json:"currency"`
+	Rate       string    `json:"rate"`
+	MinorUnits int       `json:"minor_units"`
+	Base       bool      `json:"base"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func ValidateExchangeRate(v *validator.Validator, rate *ExchangeRate) {
+	v.Check(validator.Matches(rate.Currency, CurrencyRX), "currency", "must be a three letter ISO 4217 code")
+	r, ok := new(big.Rat).SetString(rate.Rate)
+	v.Check(ok && r.Sign() > 0, "rate", "must be a positive decimal number")
+	v.Check(rate.MinorUnits >= 0 && rate.MinorUnits <= 4, "minor_units", "must be between 0 and 4")
+}
+
+// Rates converts amounts between the currencies of the exchange rate table.
+type Rates struct {
+	Base  string
+	rates map[string]rate
+}
+
+type rate struct {
+	rate       *big.Rat
+	minorUnits int
+}
+
+// Supports reports whether amounts can be converted to and from currency.
+func (r Rates) Supports(currency string) bool {
+	_, ok := r.rates[currency]
+	return ok
+}
+
+// Convert converts an amount in minor units of one currency into minor units
+// of another. The conversion is exact up to the final rounding, which rounds
+// half to even so that the same amount always converts the same way.
+func (r Rates) Convert(amount int64, from, to string) (int64, error) {
+	if from == to {
+		return amount, nil
+	}
+	f, ok := r.rates[from]
+	if !ok {
+		return 0, ErrUnsupportedCurrency
+	}
+	t, ok := r.rates[to]
+	if !ok {
+		return 0, ErrUnsupportedCurrency
+	}
+	x := new(big.Rat).SetInt64(amount)
+	x.Mul(x, t.rate)
+	x.Quo(x, f.rate)
+	x.Mul(x, new(big.Rat).SetInt(pow10(t.minorUnits)))
+	x.Quo(x, new(big.Rat).SetInt(pow10(f.minorUnits)))
+	return roundHalfEven(x), nil
+}
+
+// Amount turns whole units of currency into minor units, e.g. 5 USD into 500.
+func (r Rates) Amount(units int64, currency string) int64 {
+	return units * pow10(r.rates[currency].minorUnits).Int64()
+}
+
+// Format writes an amount in minor units as a decimal amount followed by the
+// currency code, e.g. "19.99 USD".
+func (r Rates) Format(amount int64, currency string) string {
+	minorUnits := r.rates[currency].minorUnits
+	x := new(big.Rat).SetFrac(big.NewInt(amount), pow10(minorUnits))
+	return x.FloatString(minorUnits) + " " + currency
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func roundHalfEven(x *big.Rat) int64 {
+	q, rem := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int))
+	// Compare twice the remainder with the denominator to find out whether
+	// the dropped fraction is below, at or above one half.
+	half := new(big.Int).Abs(rem)
+	half.Lsh(half, 1)
+	switch c := half.Cmp(x.Denom()); {
+	case c > 0, c == 0 && q.Bit(0) == 1:
+		q.Add(q, big.NewInt(int64(x.Sign())))
+	}
+	return q.Int64()
+}
+
+type ExchangeRateModel struct {
+	DB *sql.DB
+}
+
+func (m ExchangeRateModel) GetAll() ([]*ExchangeRate, error) {
+	query := `
+		SELECT currency, rate, minor_units, base, updated_at
+		FROM exchange_rates
+		ORDER BY base DESC, currency`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*ExchangeRate{}
+	for rows.Next() {
+		var rate ExchangeRate
+		err := rows.Scan(&rate.Currency, &rate.Rate, &rate.MinorUnits, &rate.Base, &rate.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		// numeric comes back padded to its scale, e.g. 0.9200000000.
+		if strings.Contains(rate.Rate, ".") {
+			rate.Rate = strings.TrimRight(strings.TrimRight(rate.Rate, "0"), ".")
+		}
+		rates = append(rates, &rate)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// Rates loads the exchange rate table for conversions.
+func (m ExchangeRateModel) Rates() (Rates, error) {
+	all, err := m.GetAll()
+	if err != nil {
+		return Rates{}, err
+	}
+	return NewRates(all)
+}
+
+// NewRates prepares the exchange rates for conversions.
+func NewRates(all []*ExchangeRate) (Rates, error) {
+	rates := Rates{rates: make(map[string]rate, len(all))}
+	for _, r := range all {
+		value, ok := new(big.Rat).SetString(r.Rate)
+		if !ok || value.Sign() <= 0 {
+			return Rates{}, fmt.Errorf("invalid exchange rate %q for %s", r.Rate, r.Currency)
+		}
+		rates.rates[r.Currency] = rate{rate: value, minorUnits: r.MinorUnits}
+		if r.Base {
+			rates.Base = r.Currency
+		}
+	}
+	return rates, nil
+}
+
+// Upsert adds or updates the rate of a currency. The base currency always has a
+// rate of 1, ErrBaseCurrency is returned when it is changed.
+func (m ExchangeRateModel) Upsert(rate *ExchangeRate) error {
+	query := `
+		INSERT INTO exchange_rates (currency, rate, minor_units)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (currency) DO UPDATE
+		SET rate = EXCLUDED.rate, minor_units = EXCLUDED.minor_units, updated_at = NOW()
+		WHERE NOT exchange_rates.base
+		RETURNING base, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, rate.Currency, rate.Rate, rate.MinorUnits).Scan(&rate.Base, &rate.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrBaseCurrency
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a currency. The base currency and currencies that wallets
+// are kept in cannot be removed.
+func (m ExchangeRateModel) Delete(currency string) error {
+	query := `
+		DELETE FROM exchange_rates
+		WHERE currency = $1
+		RETURNING base`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var base bool
+	err = tx.QueryRowContext(ctx, query, currency).Scan(&base)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case strings.HasPrefix(err.Error(), `pq: update or delete on table "exchange_rates" violates foreign key constraint`):
+			return ErrCurrencyInUse
+		default:
+			return err
+		}
+	}
+	if base {
+		return ErrBaseCurrency
+	}
+	return tx.Commit()
+}
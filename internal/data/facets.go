@@ -0,0 +1,147 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// PriceBuckets are the lower bounds of the price ranges counted by the price
+// facet, in minor units of the currency prices are shown in. The last bucket is
+// open ended.
+var PriceBuckets = []int64{0, 5000, 10000, 20000, 50000, 100000}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type Facets struct {
+	Brands     []FacetCount `json:"brands"`
+	Sizes      []FacetCount `json:"sizes"`
+	Colors     []FacetCount `json:"colors"`
+	Categories []FacetCount `json:"categories"`
+	Sex        []FacetCount `json:"sex"`
+	Prices     []FacetCount `json:"prices"`
+}
+
+// GetFacets counts the clothes matching q for every value of each facet. Each
+// facet applies every filter of q except its own, so a client can show how many
+// items it would get by switching to another value. Color, size and price are
+// counted over the variants matching the other filters, a clothe is counted
+// once per value. priceBounds are the PriceBuckets converted into the base
+// currency, the price facet is labelled with the PriceBuckets themselves.
+func (m ClotheModel) GetFacets(q ClotheQuery, priceBounds []int64) (*Facets, error) {
+	var facets Facets
+	var err error
+
+	facets.Brands, err = m.countFacet(q, "brand", "brands.name", "")
+	if err != nil {
+		return nil, err
+	}
+	facets.Sizes, err = m.countFacet(q, "sizes", "size", "CROSS JOIN LATERAL "+clotheVariantSet+" AS variant CROSS JOIN LATERAL unnest(variant.sizes) AS size")
+	if err != nil {
+		return nil, err
+	}
+	facets.Colors, err = m.countFacet(q, "color", "variant.color", "CROSS JOIN LATERAL "+clotheVariantSet+" AS variant")
+	if err != nil {
+		return nil, err
+	}
+	facets.Categories, err = m.countFacet(q, "category", "categories.slug", "")
+	if err != nil {
+		return nil, err
+	}
+	facets.Sex, err = m.countFacet(q, "sex", "clothes.sex", "")
+	if err != nil {
+		return nil, err
+	}
+	facets.Prices, err = m.countPriceFacet(q, priceBounds)
+	if err != nil {
+		return nil, err
+	}
+	return &facets, nil
+}
+
+// countFacet counts the clothes for every value of column. A join of the
+// variants restricts the count to the variants matching the filters.
+func (m ClotheModel) countFacet(q ClotheQuery, skip string, column string, join string) ([]FacetCount, error) {
+	where, args := q.where(skip)
+	if join != "" {
+		where, args = q.variantWhere(skip)
+	}
+	query := fmt.Sprintf(`
+		SELECT %s, COUNT(DISTINCT clothes.id)
+		FROM %s %s
+		%s
+		GROUP BY 1
+		ORDER BY 2 DESC, 1`, column, clotheJoins, join, where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+	for rows.Next() {
+		var count FacetCount
+		err := rows.Scan(&count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (m ClotheModel) countPriceFacet(q ClotheQuery, bounds []int64) ([]FacetCount, error) {
+	where, args := q.variantWhere("price")
+	query := fmt.Sprintf(`
+		SELECT width_bucket(variant.price::bigint, $%d::bigint[]), COUNT(DISTINCT clothes.id)
+		FROM %s CROSS JOIN LATERAL %s AS variant
+		%s
+		GROUP BY 1
+		ORDER BY 1`, len(args)+1, clotheJoins, clotheVariantSet, where)
+	args = append(args, pq.Array(bounds))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+	for rows.Next() {
+		var bucket int
+		var count FacetCount
+		err := rows.Scan(&bucket, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		// width_bucket numbers the buckets from 1, prices are never below the
+		// first bound.
+		if bucket < 1 {
+			continue
+		}
+		if bucket == len(PriceBuckets) {
+			count.Value = fmt.Sprintf("%d+", PriceBuckets[bucket-1])
+		} else {
+			count.Value = fmt.Sprintf("%d-%d", PriceBuckets[bucket-1], PriceBuckets[bucket]-1)
+		}
+		counts = append(counts, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
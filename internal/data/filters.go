@@ -2,6 +2,8 @@ package data
 
 import (
 	"clothing-store/internal/validator"
+	"encoding/base64"
+	"encoding/json"
 	"strings"
 )
 
@@ -10,15 +12,44 @@ type Filters struct {
 	PageSize     int64
 	Sort         string
 	SortSafelist []string
+	// Cursor, when set, replaces Page: the listing continues right after the
+	// row the cursor was taken from.
+	Cursor string
+}
+
+// cursor is the decoded form of Filters.Cursor. It holds the sort key and id of
+// the last row of the previous page.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, bool) {
+	var c cursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, false
+	}
+	if json.Unmarshal(js, &c) != nil || c.ID < 1 {
+		return c, false
+	}
+	return c, true
 }
 
 type Keys struct {
-	PriceMax       int64
-	PriceMin       int64
-	Brand          string
-	Sizes          []string
-	SizesSafelist  []string
-	BrandsSafelist []string
+	PriceMax      int64
+	PriceMin      int64
+	Sizes         []string
+	SizesMatch    string
+	SizesSafelist []string
+	Sex           string
+	RatingMin     float64
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -29,20 +60,28 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	if f.Cursor != "" {
+		c, ok := decodeCursor(f.Cursor)
+		v.Check(ok, "cursor", "invalid cursor")
+		v.Check(!ok || c.Sort == f.Sort, "cursor", "was created for a different sort")
+	}
 }
 
 func ValidateKeys(v *validator.Validator, k Keys) {
-
-	for i := 0; i < len(k.BrandsSafelist); i++ {
-		k.BrandsSafelist[i] = strings.ToLower(k.BrandsSafelist[i])
-	}
 	v.Check(k.PriceMin >= 0, "price_min", "must be greater or equal to zero")
-	v.Check(k.PriceMax > 0, "price_max", "must be greater than zero")
-	v.Check(k.PriceMax > k.PriceMin, "price", "price_max must be greater than price_min")
-	v.Check(validator.PermittedValue(strings.ToLower(k.Brand), k.BrandsSafelist...), "brand", "do not have this brand")
+	v.Check(k.PriceMax >= 0, "price_max", "must be greater or equal to zero")
+	// A price_max of zero leaves the price unbounded from above.
+	if k.PriceMax > 0 {
+		v.Check(k.PriceMax >= k.PriceMin, "price", "price_max must be greater than or equal to price_min")
+	}
 	for i := 0; i < len(k.Sizes); i++ {
 		v.Check(validator.PermittedValue(strings.ToUpper(k.Sizes[i]), k.SizesSafelist...), "size", "invalid size value")
 	}
+	v.Check(validator.PermittedValue(k.SizesMatch, SizesMatchSafelist...), "sizes_match", "must be either all or any")
+	v.Check(k.RatingMin >= 0 && k.RatingMin <= 5, "rating_min", "must be between 0 and 5")
+	if k.Sex != "" {
+		v.Check(validator.PermittedValue(strings.ToLower(k.Sex), SexSafelist...), "sex", "must be one of men, women or unisex")
+	}
 }
 
 func (f Filters) sortColumn() string {
@@ -65,5 +104,30 @@ func (f Filters) limit() int64 {
 	return f.PageSize
 }
 func (f Filters) offset() int64 {
+	if f.Cursor != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
+
+type Metadata struct {
+	CurrentPage  int64  `json:"current_page,omitempty"`
+	PageSize     int64  `json:"page_size,omitempty"`
+	FirstPage    int64  `json:"first_page,omitempty"`
+	LastPage     int64  `json:"last_page,omitempty"`
+	TotalRecords int64  `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+func calculateMetadata(totalRecords, page, pageSize int64) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (totalRecords + pageSize - 1) / pageSize,
+		TotalRecords: totalRecords,
+	}
+}
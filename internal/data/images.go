@@ -0,0 +1,302 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxImageSize is the largest image upload accepted, 5 MB.
+const MaxImageSize = 5 << 20
+
+var ImageContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+type ClotheImage struct {
+	ID          int64     `json:"id"`
+	ClotheID    int64     `json:"-"`
+	VariantID   *int64    `json:"variant_id,omitempty"`
+	StorageKey  string    `json:"-"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"content_type"`
+	AltText     string    `json:"alt_text"`
+	Position    int64     `json:"position"`
+	Primary     bool      `json:"primary"`
+	Srcset      Srcset    `json:"srcset,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Srcset maps width descriptors such as "640w" to the URLs of the resized JPEG
+// variants of an image, and descriptors followed by the format such as
+// "640w.webp" to the other variants. It is stored as a jsonb object.
+type Srcset map[string]string
+
+func (s *Srcset) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Srcset", src)
+	}
+	return json.Unmarshal(b, s)
+}
+
+func (s Srcset) Value() (driver.Value, error) {
+	if s == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(s)
+}
+
+func ValidateClotheImage(v *validator.Validator, image *ClotheImage) {
+	_, ok := ImageContentTypes[image.ContentType]
+	v.Check(ok, "image", "must be a JPEG, PNG, WebP or GIF image")
+	v.Check(len(image.AltText) <= 300, "alt_text", "must not be more than 300 bytes long")
+	v.Check(image.Position >= 0, "position", "must be zero or a positive integer")
+}
+
+type ImageModel struct {
+	DB *sql.DB
+}
+
+// Insert adds the image to the gallery of its clothe. The first image of a
+// clothe always becomes the primary one.
+func (m ImageModel) Insert(image *ClotheImage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM clothe_images WHERE clothe_id = $1`, image.ClotheID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	makePrimary := image.Primary || count == 0
+
+	query := `
+		INSERT INTO clothe_images (clothe_id, variant_id, storage_key, url, content_type, alt_text, position)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+	args := []any{image.ClotheID, image.VariantID, image.StorageKey, image.URL, image.ContentType, image.AltText, image.Position}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if makePrimary {
+		err = setPrimaryImage(ctx, tx, image.ClotheID, image.ID)
+		if err != nil {
+			return err
+		}
+		image.Primary = true
+	}
+	return tx.Commit()
+}
+
+func (m ImageModel) Get(clotheID, id int64) (*ClotheImage, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, clothe_id, variant_id, storage_key, url, content_type, alt_text, position, is_primary, variants, created_at
+		FROM clothe_images
+		WHERE clothe_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	image, err := scanClotheImage(m.DB.QueryRowContext(ctx, query, clotheID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return image, nil
+}
+
+func (m ImageModel) GetAllForClothe(clotheID int64) ([]ClotheImage, error) {
+	query := `
+		SELECT id, clothe_id, variant_id, storage_key, url, content_type, alt_text, position, is_primary, variants, created_at
+		FROM clothe_images
+		WHERE clothe_id = $1
+		ORDER BY position, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, clotheID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []ClotheImage{}
+	for rows.Next() {
+		image, err := scanClotheImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Update saves the variant, alt text and position of the image. Setting Primary moves
+// the primary mark from the previous primary image, it cannot be cleared.
+func (m ImageModel) Update(image *ClotheImage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE clothe_images
+		SET variant_id = $1, alt_text = $2, position = $3
+		WHERE clothe_id = $4 AND id = $5`
+	result, err := tx.ExecContext(ctx, query, image.VariantID, image.AltText, image.Position, image.ClotheID, image.ID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	if image.Primary {
+		err = setPrimaryImage(ctx, tx, image.ClotheID, image.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes the image and returns it so that the caller can delete the
+// stored file. When the primary image is removed the next one in order takes
+// its place.
+func (m ImageModel) Delete(clotheID, id int64) (*ClotheImage, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM clothe_images
+		WHERE clothe_id = $1 AND id = $2
+		RETURNING id, clothe_id, variant_id, storage_key, url, content_type, alt_text, position, is_primary, variants, created_at`
+	image, err := scanClotheImage(tx.QueryRowContext(ctx, query, clotheID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if image.Primary {
+		var nextID int64
+		err = tx.QueryRowContext(ctx, `SELECT id FROM clothe_images WHERE clothe_id = $1 ORDER BY position, id LIMIT 1`, clotheID).Scan(&nextID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = tx.ExecContext(ctx, `UPDATE clothes SET image_url = '' WHERE id = $1`, clotheID)
+		case err == nil:
+			err = setPrimaryImage(ctx, tx, clotheID, nextID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return image, tx.Commit()
+}
+
+// SetVariants records the resized variants of the image. ErrRecordNotFound is
+// returned when the image was deleted in the meantime.
+func (m ImageModel) SetVariants(clotheID, id int64, srcset Srcset) error {
+	query := `
+		UPDATE clothe_images
+		SET variants = $1
+		WHERE clothe_id = $2 AND id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, srcset, clotheID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// setPrimaryImage marks the image as the primary one of its clothe and copies
+// its URL to clothes.image_url, which listings keep showing.
+func setPrimaryImage(ctx context.Context, tx *sql.Tx, clotheID, id int64) error {
+	// Clear the old mark first, the unique index allows a single primary image.
+	_, err := tx.ExecContext(ctx, `UPDATE clothe_images SET is_primary = false WHERE clothe_id = $1 AND is_primary AND id <> $2`, clotheID, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE clothe_images SET is_primary = true WHERE clothe_id = $1 AND id = $2`, clotheID, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE clothes SET image_url = (SELECT url FROM clothe_images WHERE id = $2) WHERE id = $1`, clotheID, id)
+	return err
+}
+
+func scanClotheImage(row rowScanner) (*ClotheImage, error) {
+	var image ClotheImage
+	err := row.Scan(
+		&image.ID,
+		&image.ClotheID,
+		&image.VariantID,
+		&image.StorageKey,
+		&image.URL,
+		&image.ContentType,
+		&image.AltText,
+		&image.Position,
+		&image.Primary,
+		&image.Srcset,
+		&image.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
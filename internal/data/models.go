@@ -11,23 +11,55 @@ var (
 )
 
 type Models struct {
-	Clothes     ClotheModel
-	Users       UserModel
-	Brands      BrandModel
-	Tokens      TokenModel
-	Permissions PermissionModel
-	Roles       RolesModel
-	Carts       CartsModel
+	Clothes       ClotheModel
+	Users         UserModel
+	Brands        BrandModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	Roles         RolesModel
+	Carts         CartsModel
+	Categories    CategoryModel
+	Search        SearchModel
+	Images        ImageModel
+	Variants      VariantModel
+	Reviews       ReviewModel
+	Wishlists     WishlistModel
+	Alerts        AlertModel
+	Related       RelatedModel
+	Sizes         SizeModel
+	SizeSystems   SizeSystemModel
+	SizeCharts    SizeChartModel
+	ExchangeRates ExchangeRateModel
+	Translations  TranslationModel
+	Bundles       BundleModel
+	Collections   CollectionModel
+	Audit         AuditModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Clothes:     ClotheModel{DB: db},
-		Users:       UserModel{DB: db},
-		Brands:      BrandModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Roles:       RolesModel{DB: db},
-		Carts:       CartsModel{DB: db},
+		Clothes:       ClotheModel{DB: db},
+		Users:         UserModel{DB: db},
+		Brands:        BrandModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		Roles:         RolesModel{DB: db},
+		Carts:         CartsModel{DB: db},
+		Categories:    CategoryModel{DB: db},
+		Search:        SearchModel{DB: db, cache: newSuggestCache()},
+		Images:        ImageModel{DB: db},
+		Variants:      VariantModel{DB: db},
+		Reviews:       ReviewModel{DB: db},
+		Wishlists:     WishlistModel{DB: db},
+		Alerts:        AlertModel{DB: db},
+		Related:       RelatedModel{DB: db},
+		Sizes:         SizeModel{DB: db},
+		SizeSystems:   SizeSystemModel{DB: db},
+		SizeCharts:    SizeChartModel{DB: db},
+		ExchangeRates: ExchangeRateModel{DB: db},
+		Translations:  TranslationModel{DB: db},
+		Bundles:       BundleModel{DB: db},
+		Collections:   CollectionModel{DB: db},
+		Audit:         AuditModel{DB: db},
 	}
 }
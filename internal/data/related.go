@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RelatedPerClothe is the number of recommendations kept for every clothe.
+const RelatedPerClothe = 20
+
+type RelatedModel struct {
+	DB *sql.DB
+}
+
+// Refresh rebuilds the related_clothes table. Clothes on sale are related when
+// they share a category or were bought by the same users; the score adds up
+// the shared category, brand, color and sex and grows with the number of
+// users who bought both. Carts are the purchase history, there is no separate
+// orders table.
+func (m RelatedModel) Refresh() error {
+	query := `
+		WITH on_sale AS (
+			SELECT id, brand_id, category_id, lower(color) AS color, sex
+			FROM clothes
+			WHERE deleted_at IS NULL
+		), bought AS (
+			SELECT DISTINCT carts.user_id, item.clothe_id
+			FROM carts CROSS JOIN LATERAL unnest(carts.clothes_id) AS item(clothe_id)
+		), co_purchases AS (
+			SELECT a.clothe_id, b.clothe_id AS related_id, COUNT(*) AS count
+			FROM bought a INNER JOIN bought b ON b.user_id = a.user_id AND b.clothe_id <> a.clothe_id
+			GROUP BY a.clothe_id, b.clothe_id
+		), candidates AS (
+			SELECT a.id AS clothe_id, b.id AS related_id,
+				CASE WHEN a.category_id = b.category_id THEN 3 ELSE 0 END
+				+ CASE WHEN a.brand_id = b.brand_id THEN 2 ELSE 0 END
+				+ CASE WHEN a.color = b.color THEN 1 ELSE 0 END
+				+ CASE WHEN a.sex = b.sex OR 'unisex' IN (a.sex, b.sex) THEN 1 ELSE 0 END
+				+ 4 * ln(1 + COALESCE(co_purchases.count, 0)) AS score
+			FROM on_sale a INNER JOIN on_sale b ON b.id <> a.id
+			LEFT JOIN co_purchases ON co_purchases.clothe_id = a.id AND co_purchases.related_id = b.id
+			WHERE a.category_id = b.category_id OR co_purchases.count IS NOT NULL
+		), ranked AS (
+			SELECT clothe_id, related_id, score,
+				row_number() OVER (PARTITION BY clothe_id ORDER BY score DESC, related_id) AS rank
+			FROM candidates
+		)
+		INSERT INTO related_clothes (clothe_id, related_id, score)
+		SELECT clothe_id, related_id, score
+		FROM ranked
+		WHERE rank <= $1`
+
+	// The whole catalogue is scanned, which takes longer than a request.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM related_clothes`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, RelatedPerClothe)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAll returns the clothes related to the clothe, best first. Archived
+// clothes and clothes already in the cart of userID are left out.
+func (m RelatedModel) GetAll(clotheID, userID int64, limit int) ([]*Clothe, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM related_clothes INNER JOIN %s ON clothes.id = related_clothes.related_id
+		WHERE related_clothes.clothe_id = $1
+		AND clothes.deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM carts WHERE carts.user_id = $2 AND clothes.id = ANY(carts.clothes_id))
+		ORDER BY related_clothes.score DESC, clothes.id
+		LIMIT $3`, clotheColumns, clotheJoins)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, clotheID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clothes := []*Clothe{}
+	for rows.Next() {
+		clothe, err := scanClothe(rows)
+		if err != nil {
+			return nil, err
+		}
+		clothes = append(clothes, clothe)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return clothes, nil
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	FitSafelist          = []string{"runs_small", "true_to_size", "runs_large"}
+	ReviewStatusSafelist = []string{"pending", "published", "rejected"}
+
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+type Review struct {
+	ID        int64     `json:"id"`
+	ClotheID  int64     `json:"clothe_id"`
+	UserID    int64     `json:"-"`
+	UserName  string    `json:"user_name"`
+	Rating    int64     `json:"rating"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	Fit       *string   `json:"fit,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Rating summarises the published reviews of a clothe.
+type Rating struct {
+	Average float64 `json:"average"`
+	Count   int64   `json:"count"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(review.Title != "", "title", "must be provided")
+	v.Check(len(review.Title) <= 200, "title", "must not be more than 200 bytes long")
+	v.Check(review.Body != "", "body", "must be provided")
+	v.Check(len(review.Body) <= 5000, "body", "must not be more than 5000 bytes long")
+	if review.Fit != nil {
+		v.Check(validator.PermittedValue(*review.Fit, FitSafelist...), "fit", "must be one of runs_small, true_to_size or runs_large")
+	}
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// Insert saves a new review. It waits for moderation before it is shown and
+// counted in the rating of the clothe.
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+		INSERT INTO reviews (clothe_id, user_id, rating, title, body, fit)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, created_at`
+	args := []any{review.ClotheID, review.UserID, review.Rating, review.Title, review.Body, review.Fit}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.Status, &review.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_clothe_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+const reviewColumns = `reviews.id, reviews.clothe_id, reviews.user_id, users.name, reviews.rating,
+		reviews.title, reviews.body, reviews.fit, reviews.status, reviews.created_at`
+
+func (m ReviewModel) Get(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM reviews INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.id = $1`, reviewColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	review, err := scanReview(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return review, nil
+}
+
+// GetAll lists the reviews of a clothe, or of every clothe when clotheID is
+// zero. An empty status lists reviews of every status.
+func (m ReviewModel) GetAll(clotheID int64, status string, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM reviews INNER JOIN users ON users.id = reviews.user_id
+		WHERE (reviews.clothe_id = $1 OR $1 = 0)
+		AND (reviews.status = $2 OR $2 = '')
+		ORDER BY %s %s, reviews.id ASC
+		LIMIT $3 OFFSET $4`, reviewColumns, "reviews."+filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, clotheID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := int64(0)
+	reviews := []*Review{}
+	for rows.Next() {
+		review, err := scanReview(countingScanner{rows: rows, total: &totalRecords})
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
+
+// GetAllForUser lists the reviews the user wrote, of every status, most recent
+// first.
+func (m ReviewModel) GetAllForUser(userID int64) ([]*Review, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM reviews INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.user_id = $1
+		ORDER BY reviews.created_at DESC, reviews.id DESC`, reviewColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+// UpdateStatus moderates the review and recalculates the rating of its clothe.
+func (m ReviewModel) UpdateStatus(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE reviews SET status = $1 WHERE id = $2`, review.Status, review.ID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	err = refreshRating(ctx, tx, review.ClotheID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m ReviewModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var clotheID int64
+	err = tx.QueryRowContext(ctx, `DELETE FROM reviews WHERE id = $1 RETURNING clothe_id`, id).Scan(&clotheID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	err = refreshRating(ctx, tx, clotheID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// refreshRating stores the average and count of the published reviews on the
+// clothe, where listings sort and filter on them.
+func refreshRating(ctx context.Context, tx *sql.Tx, clotheID int64) error {
+	query := `
+		UPDATE clothes
+		SET (rating_average, rating_count) = (
+			SELECT COALESCE(AVG(rating), 0), COUNT(*)
+			FROM reviews
+			WHERE clothe_id = $1 AND status = 'published'
+		)
+		WHERE id = $1`
+	_, err := tx.ExecContext(ctx, query, clotheID)
+	return err
+}
+
+func scanReview(row rowScanner) (*Review, error) {
+	var review Review
+	err := row.Scan(
+		&review.ID,
+		&review.ClotheID,
+		&review.UserID,
+		&review.UserName,
+		&review.Rating,
+		&review.Title,
+		&review.Body,
+		&review.Fit,
+		&review.Status,
+		&review.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
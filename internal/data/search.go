@@ -0,0 +1,153 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// SuggestionsPerGroup caps the suggestions returned for each kind of match.
+	SuggestionsPerGroup = 5
+
+	// Only short prefixes are cached, they are the ones typed by nearly every
+	// user before the search term becomes specific.
+	suggestCacheMaxPrefix = 4
+	suggestCacheSize      = 1000
+	suggestCacheTTL       = time.Minute
+)
+
+type Suggestion struct {
+	ID   int64  `json:"id"`
+	Text string `json:"text"`
+	Slug string `json:"slug,omitempty"`
+}
+
+type Suggestions struct {
+	Products   []Suggestion `json:"products"`
+	Brands     []Suggestion `json:"brands"`
+	Categories []Suggestion `json:"categories"`
+}
+
+type SearchModel struct {
+	DB    *sql.DB
+	cache *suggestCache
+}
+
+// Suggest returns the products, brands and categories whose names start with
+// prefix or are close to it. Prefix matches are listed before fuzzy ones.
+func (m SearchModel) Suggest(prefix string) (*Suggestions, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if m.cache != nil {
+		if suggestions, ok := m.cache.get(prefix); ok {
+			return suggestions, nil
+		}
+	}
+
+	var suggestions Suggestions
+	var err error
+
+	suggestions.Products, err = m.suggest(prefix, "clothes.id, clothes.name, ''", "clothes", "clothes.name", "clothes.deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	suggestions.Brands, err = m.suggest(prefix, "brands.id, brands.name, ''", "brands", "brands.name", "brands.deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	suggestions.Categories, err = m.suggest(prefix, "categories.id, categories.name, categories.slug", "categories", "categories.name", "TRUE")
+	if err != nil {
+		return nil, err
+	}
+
+	if m.cache != nil && utf8.RuneCountInString(prefix) <= suggestCacheMaxPrefix {
+		m.cache.set(prefix, &suggestions)
+	}
+	return &suggestions, nil
+}
+
+func (m SearchModel) suggest(prefix, columns, table, name, condition string) ([]Suggestion, error) {
+	query := `
+		SELECT ` + columns + `
+		FROM ` + table + `
+		WHERE ` + condition + `
+		AND (` + name + ` ILIKE $1 || '%' OR $2 <% ` + name + `)
+		ORDER BY ` + name + ` ILIKE $1 || '%' DESC, word_similarity($2, ` + name + `) DESC, ` + name + `
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(prefix), prefix, SuggestionsPerGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Text, &suggestion.Slug)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// escapeLike makes the LIKE wildcards in s match literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type suggestCacheEntry struct {
+	suggestions *Suggestions
+	expires     time.Time
+}
+
+// suggestCache keeps the suggestions of recently typed prefixes. Entries
+// expire after suggestCacheTTL so that catalogue changes show up shortly.
+type suggestCache struct {
+	mu      sync.Mutex
+	entries map[string]suggestCacheEntry
+}
+
+func newSuggestCache() *suggestCache {
+	return &suggestCache{entries: make(map[string]suggestCacheEntry)}
+}
+
+func (c *suggestCache) get(prefix string) (*Suggestions, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[prefix]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.suggestions, true
+}
+
+func (c *suggestCache) set(prefix string, suggestions *Suggestions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= suggestCacheSize {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		// Everything is still fresh, the prefixes already cached are the
+		// popular ones.
+		if len(c.entries) >= suggestCacheSize {
+			return
+		}
+	}
+	c.entries[prefix] = suggestCacheEntry{suggestions: suggestions, expires: now.Add(suggestCacheTTL)}
+}
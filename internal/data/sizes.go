@@ -0,0 +1,436 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"math"
+	"strings"
+	"time"
+)
+
+// SizePurchase is a size the user bought before, of a clothe sharing the brand,
+// the category or the size system of the clothe a size is recommended for. Fit
+// is the fit the user reported when reviewing that clothe and Measurements
+// the row of the size in the size chart of its brand, empty without a chart.
+type SizePurchase struct {
+	Size         string
+	SameBrand    bool
+	SameCategory bool
+	Fit          *string
+	Measurements Measurements
+}
+
+// FitCounts counts the fit feedback of published reviews.
+type FitCounts struct {
+	RunsSmall  int64
+	TrueToSize int64
+	RunsLarge  int64
+}
+
+// PurchaseSize is the size a clothe was bought in.
+type PurchaseSize struct {
+	ClotheID  int64     `json:"clothe_id"`
+	Size      string    `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SizeRecommendation struct {
+	Size        string  `json:"size"`
+	Confidence  float64 `json:"confidence"`
+	Explanation string  `json:"explanation"`
+}
+
+type SizeModel struct {
+	DB *sql.DB
+}
+
+// InsertPurchase records the size the user bought the clothe in.
+func (m SizeModel) InsertPurchase(userID, clotheID int64, size string) error {
+	query := `
+		INSERT INTO purchase_sizes (user_id, clothe_id, size)
+		VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, clotheID, size)
+	return err
+}
+
+// GetAllPurchases lists the sizes the user bought clothes in, most recent
+// first.
+func (m SizeModel) GetAllPurchases(userID int64) ([]*PurchaseSize, error) {
+	query := `
+		SELECT clothe_id, size, created_at
+		FROM purchase_sizes
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []*PurchaseSize{}
+	for rows.Next() {
+		var purchase PurchaseSize
+		err := rows.Scan(&purchase.ClotheID, &purchase.Size, &purchase.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, &purchase)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return purchases, nil
+}
+
+// History returns the sizes the user bought of other clothes of the same
+// brand, category or size system as the clothe, most recent first.
+func (m SizeModel) History(userID int64, clothe *Clothe) ([]SizePurchase, error) {
+	query := `
+		SELECT purchase_sizes.size, clothes.brand_id = $2, clothes.category_id = $3, reviews.fit,
+			COALESCE(brand_size_charts.measurements, '{}')
+		FROM purchase_sizes
+		INNER JOIN clothes ON clothes.id = purchase_sizes.clothe_id
+		LEFT JOIN reviews ON reviews.clothe_id = purchase_sizes.clothe_id AND reviews.user_id = purchase_sizes.user_id
+		LEFT JOIN brand_size_charts ON brand_size_charts.brand_id = clothes.brand_id
+			AND brand_size_charts.size_system_id = clothes.size_system_id
+			AND upper(brand_size_charts.size) = upper(purchase_sizes.size)
+		WHERE purchase_sizes.user_id = $1 AND purchase_sizes.clothe_id <> $4
+		AND (clothes.brand_id = $2 OR clothes.category_id = $3 OR clothes.size_system_id = $5)
+		ORDER BY purchase_sizes.created_at DESC, purchase_sizes.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, clothe.BrandID, clothe.CategoryID, clothe.ID, clothe.SizeSystemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []SizePurchase{}
+	for rows.Next() {
+		var purchase SizePurchase
+		err := rows.Scan(&purchase.Size, &purchase.SameBrand, &purchase.SameCategory, &purchase.Fit, &purchase.Measurements)
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, purchase)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return purchases, nil
+}
+
+// Fit counts the fit feedback of the published reviews of the clothe and of
+// every clothe of its brand.
+func (m SizeModel) Fit(clothe *Clothe) (clotheFit, brandFit FitCounts, err error) {
+	query := `
+		SELECT COUNT(*) FILTER (WHERE reviews.clothe_id = $1 AND reviews.fit = 'runs_small'),
+			COUNT(*) FILTER (WHERE reviews.clothe_id = $1 AND reviews.fit = 'true_to_size'),
+			COUNT(*) FILTER (WHERE reviews.clothe_id = $1 AND reviews.fit = 'runs_large'),
+			COUNT(*) FILTER (WHERE reviews.fit = 'runs_small'),
+			COUNT(*) FILTER (WHERE reviews.fit = 'true_to_size'),
+			COUNT(*) FILTER (WHERE reviews.fit = 'runs_large')
+		FROM reviews INNER JOIN clothes ON clothes.id = reviews.clothe_id
+		WHERE clothes.brand_id = $2 AND reviews.status = 'published'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, clothe.ID, clothe.BrandID).Scan(
+		&clotheFit.RunsSmall,
+		&clotheFit.TrueToSize,
+		&clotheFit.RunsLarge,
+		&brandFit.RunsSmall,
+		&brandFit.TrueToSize,
+		&brandFit.RunsLarge,
+	)
+	return clotheFit, brandFit, err
+}
+
+// PopularSize returns the size of the clothe that customers buy most often in
+// its brand and category, or an empty string when nobody bought any.
+func (m SizeModel) PopularSize(clothe *Clothe) (string, error) {
+	query := `
+		SELECT purchase_sizes.size
+		FROM purchase_sizes INNER JOIN clothes ON clothes.id = purchase_sizes.clothe_id
+		WHERE clothes.brand_id = $1 AND clothes.category_id = $2 AND purchase_sizes.size = ANY($3)
+		GROUP BY purchase_sizes.size
+		ORDER BY COUNT(*) DESC, purchase_sizes.size
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var size string
+	err := m.DB.QueryRowContext(ctx, query, clothe.BrandID, clothe.CategoryID, pq.Array(clothe.Sizes)).Scan(&size)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	return size, nil
+}
+
+// RecommendSize picks a size from clothe.Sizes, which belong to system. The
+// size the user bought most often in the same brand and category is preferred,
+// then in the same category and then in the same brand. Without such purchases
+// the size whose row in chart, the size chart of the brand, measures closest
+// to the last size the user bought in another charted brand is used. Then
+// comes the most popular size of the brand, and the middle size as a last
+// resort. The pick is moved one size up or down when the user's own reviews,
+// the reviews of the clothe or those of its brand say it runs small or large.
+func RecommendSize(clothe *Clothe, system *SizeSystem, history []SizePurchase, chart []SizeChartRow, clotheFit, brandFit FitCounts, popular string) *SizeRecommendation {
+	sizes := orderSizes(clothe.Sizes, system)
+	if len(sizes) == 0 {
+		return nil
+	}
+	index, confidence := -1, 0.0
+	var reasons []string
+
+	tiers := []struct {
+		brand, category bool
+		confidence      float64
+		label           string
+	}{
+		{true, true, 0.9, "of this brand and category"},
+		{false, true, 0.75, "of this category"},
+		{true, false, 0.6, "of this brand"},
+	}
+	for _, tier := range tiers {
+		counts := map[string]int{}
+		var mode string
+		total := 0
+		for _, purchase := range history {
+			if (tier.brand && !purchase.SameBrand) || (tier.category && !purchase.SameCategory) {
+				continue
+			}
+			size := strings.ToUpper(purchase.Size)
+			counts[size]++
+			total++
+			// History is most recent first, so a tie keeps the recent size.
+			if counts[size] > counts[mode] {
+				mode = size
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		index = nearestSize(sizes, mode, system)
+		if index < 0 {
+			continue
+		}
+		confidence = tier.confidence * float64(counts[mode]) / float64(total)
+		if !strings.EqualFold(sizes[index], mode) {
+			confidence -= 0.1
+		}
+		reasons = append(reasons, fmt.Sprintf("You bought %s in %d of your %d purchases %s.", mode, counts[mode], total, tier.label))
+
+		shift := 0
+		for _, purchase := range history {
+			if strings.EqualFold(purchase.Size, mode) && purchase.Fit != nil {
+				shift += fitShift(*purchase.Fit)
+			}
+		}
+		switch {
+		case shift > 0:
+			index++
+			reasons = append(reasons, "Your reviews said that size ran small.")
+		case shift < 0:
+			index--
+			reasons = append(reasons, "Your reviews said that size ran large.")
+		}
+		break
+	}
+
+	if index < 0 {
+		// Only the last purchase with a chart row is compared, the sizes of
+		// older ones may no longer fit.
+		for _, purchase := range history {
+			if len(purchase.Measurements) == 0 {
+				continue
+			}
+			if i := closestChartSize(sizes, chart, purchase.Measurements); i >= 0 {
+				index, confidence = i, 0.5
+				reasons = append(reasons, fmt.Sprintf("By the size charts, %s of %s measures closest to the %s you bought before.",
+					sizes[i], clothe.Brand.Name, strings.ToUpper(purchase.Size)))
+			}
+			break
+		}
+	}
+	if index < 0 {
+		index = nearestSize(sizes, popular, system)
+		if popular != "" && index >= 0 {
+			confidence = 0.4
+			reasons = append(reasons, fmt.Sprintf("Customers mostly buy %s from %s.", popular, clothe.Brand.Name))
+		} else {
+			index = (len(sizes) - 1) / 2
+			confidence = 0.2
+			reasons = append(reasons, "There is no purchase history yet, this is the middle size.")
+		}
+	}
+
+	switch shift, total := majorityFit(clotheFit); {
+	case total >= 3 && shift != 0:
+		index += shift
+		reasons = append(reasons, fmt.Sprintf("Reviewers say this clothe %s.", fitLabel(shift)))
+	case total < 3:
+		if shift, total := majorityFit(brandFit); total >= 10 && shift != 0 {
+			index += shift
+			confidence -= 0.05
+			reasons = append(reasons, fmt.Sprintf("Reviewers say %s %s.", clothe.Brand.Name, fitLabel(shift)))
+		}
+	}
+
+	if index < 0 || index >= len(sizes) {
+		index = clampIndex(index, len(sizes))
+		confidence -= 0.1
+		reasons = append(reasons, "No closer size is available.")
+	}
+	return &SizeRecommendation{
+		Size:        sizes[index],
+		Confidence:  math.Round(math.Max(confidence, 0.1)*100) / 100,
+		Explanation: strings.Join(reasons, " "),
+	}
+}
+
+// orderSizes sorts the sizes in the order of the size system, from small to
+// large. Sizes the system does not know are left out.
+func orderSizes(sizes []string, system *SizeSystem) []string {
+	ordered := []string{}
+	for _, systemSize := range system.Sizes {
+		for _, size := range sizes {
+			if strings.EqualFold(size, systemSize) {
+				ordered = append(ordered, size)
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+// sizeIndex returns the position of the size in the size system, or -1 when
+// the system does not know it. 2XL and 3XL are read as XXL and XXXL.
+func sizeIndex(system *SizeSystem, size string) int {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	if len(size) == 3 && size[0] >= '2' && size[0] <= '3' && size[1] == 'X' {
+		size = strings.Repeat("X", int(size[0]-'0')) + size[2:]
+	}
+	for i, systemSize := range system.Sizes {
+		if strings.EqualFold(systemSize, size) {
+			return i
+		}
+	}
+	return -1
+}
+
+// nearestSize returns the index of the size in sizes, or of the closest size of
+// the size system, preferring the larger one on a tie. It returns -1 when the
+// size is not part of the system.
+func nearestSize(sizes []string, size string, system *SizeSystem) int {
+	for i, s := range sizes {
+		if strings.EqualFold(s, size) {
+			return i
+		}
+	}
+	rank := sizeIndex(system, size)
+	if rank < 0 {
+		return -1
+	}
+	best, bestDistance := -1, math.MaxInt
+	for i, s := range sizes {
+		r := sizeIndex(system, s)
+		if r < 0 {
+			continue
+		}
+		distance := r - rank
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance < bestDistance || (distance == bestDistance && r > rank) {
+			best, bestDistance = i, distance
+		}
+	}
+	return best
+}
+
+// closestChartSize returns the index of the size in sizes whose row in chart
+// differs least from measurements, on average over the body parts both give.
+// It returns -1 when no row compares.
+func closestChartSize(sizes []string, chart []SizeChartRow, measurements Measurements) int {
+	best, bestDistance := -1, math.Inf(1)
+	for _, row := range chart {
+		index := -1
+		for i, size := range sizes {
+			if strings.EqualFold(size, row.Size) {
+				index = i
+				break
+			}
+		}
+		distance, parts := 0.0, 0
+		for part, cm := range row.Measurements {
+			if other, ok := measurements[part]; ok {
+				distance += math.Abs(cm - other)
+				parts++
+			}
+		}
+		if index < 0 || parts == 0 {
+			continue
+		}
+		if distance /= float64(parts); distance < bestDistance {
+			best, bestDistance = index, distance
+		}
+	}
+	return best
+}
+
+// majorityFit returns +1 when most of the fit feedback says runs small, so one
+// size up is needed, -1 for runs large and 0 otherwise, together with the
+// amount of feedback.
+func majorityFit(fit FitCounts) (int, int64) {
+	total := fit.RunsSmall + fit.TrueToSize + fit.RunsLarge
+	switch {
+	case fit.RunsSmall*2 > total:
+		return 1, total
+	case fit.RunsLarge*2 > total:
+		return -1, total
+	}
+	return 0, total
+}
+
+func fitShift(fit string) int {
+	switch fit {
+	case "runs_small":
+		return 1
+	case "runs_large":
+		return -1
+	}
+	return 0
+}
+
+func fitLabel(shift int) string {
+	if shift > 0 {
+		return "runs small, one size up"
+	}
+	return "runs large, one size down"
+}
+
+func clampIndex(index, length int) int {
+	if index < 0 {
+		return 0
+	}
+	if index >= length {
+		return length - 1
+	}
+	return index
+}
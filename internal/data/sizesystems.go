@@ -0,0 +1,252 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// DefaultSizeSystem is the slug of the size system of clothes created without
+// one.
+const DefaultSizeSystem = "alpha"
+
+// MeasurementSafelist lists the body measurements a size chart may give.
+var MeasurementSafelist = []string{"chest", "waist", "hips", "inseam", "foot_length", "height"}
+
+// SizeSystem is a way of naming sizes, such as alpha sizes or EU shoe sizes.
+// Sizes are ordered from the smallest to the largest.
+type SizeSystem struct {
+	ID    int64    `json:"id"`
+	Name  string   `json:"name"`
+	Slug  string   `json:"slug"`
+	Sizes []string `json:"sizes"`
+}
+
+// Permits reports whether size belongs to the size system.
+func (s *SizeSystem) Permits(size string) bool {
+	return validator.PermittedValue(size, s.Sizes...)
+}
+
+// SizeChart holds the measurements of every size of a brand in one size
+// system.
+type SizeChart struct {
+	SizeSystemID int64          `json:"size_system_id"`
+	SizeSystem   string         `json:"size_system"`
+	Rows         []SizeChartRow `json:"rows"`
+}
+
+type SizeChartRow struct {
+	Size         string       `json:"size"`
+	Measurements Measurements `json:"measurements"`
+}
+
+// Measurements maps a body part from MeasurementSafelist to its measurement in
+// cm. It is stored as a jsonb object.
+type Measurements map[string]float64
+
+func (m *Measurements) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Measurements", src)
+	}
+	return json.Unmarshal(b, m)
+}
+
+func (m Measurements) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+func ValidateSizeSystem(v *validator.Validator, system *SizeSystem) {
+	v.Check(system.Name != "", "name", "must be provided")
+	v.Check(len(system.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(system.Slug != "", "slug", "must be provided")
+	v.Check(validator.Matches(system.Slug, SlugRX), "slug", "must contain only lowercase letters, digits and dashes")
+	v.Check(len(system.Sizes) >= 1, "sizes", "must contain at least 1 size")
+	v.Check(validator.Unique(system.Sizes), "sizes", "must not contain duplicate values")
+	for _, size := range system.Sizes {
+		v.Check(size != "" && len(size) <= 20, "sizes", "must contain sizes of 1 to 20 bytes")
+	}
+}
+
+// ValidateSizeChart checks the rows of a size chart in the given system.
+func ValidateSizeChart(v *validator.Validator, rows []SizeChartRow, system *SizeSystem) {
+	sizes := make([]string, len(rows))
+	for i, row := range rows {
+		sizes[i] = row.Size
+		v.Check(system.Permits(row.Size), "rows", "must only contain sizes of the size system")
+		v.Check(len(row.Measurements) >= 1, "rows", "must give at least 1 measurement per size")
+		for part, cm := range row.Measurements {
+			v.Check(validator.PermittedValue(part, MeasurementSafelist...), "rows", "must only give chest, waist, hips, inseam, foot_length or height measurements")
+			v.Check(cm > 0 && cm < 300, "rows", "measurements must be between 0 and 300 cm")
+		}
+	}
+	v.Check(validator.Unique(sizes), "rows", "must not contain duplicate sizes")
+}
+
+type SizeSystemModel struct {
+	DB *sql.DB
+}
+
+func (m SizeSystemModel) Insert(system *SizeSystem) error {
+	query := `
+		INSERT INTO size_systems (name, slug, sizes)
+		VALUES ($1, $2, $3)
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, system.Name, system.Slug, pq.Array(system.Sizes)).Scan(&system.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "size_systems_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SizeSystemModel) Get(id int64) (*SizeSystem, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	return m.get(`id = $1`, id)
+}
+
+func (m SizeSystemModel) GetBySlug(slug string) (*SizeSystem, error) {
+	return m.get(`slug = $1`, slug)
+}
+
+func (m SizeSystemModel) get(condition string, arg any) (*SizeSystem, error) {
+	query := `
+		SELECT id, name, slug, sizes
+		FROM size_systems
+		WHERE ` + condition
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var system SizeSystem
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(&system.ID, &system.Name, &system.Slug, pq.Array(&system.Sizes))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &system, nil
+}
+
+func (m SizeSystemModel) GetAll() ([]*SizeSystem, error) {
+	query := `
+		SELECT id, name, slug, sizes
+		FROM size_systems
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	systems := []*SizeSystem{}
+	for rows.Next() {
+		var system SizeSystem
+		err := rows.Scan(&system.ID, &system.Name, &system.Slug, pq.Array(&system.Sizes))
+		if err != nil {
+			return nil, err
+		}
+		systems = append(systems, &system)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return systems, nil
+}
+
+type SizeChartModel struct {
+	DB *sql.DB
+}
+
+// GetAllForBrand returns the size charts of the brand, one per size system,
+// with the rows in the order of the sizes of the system.
+func (m SizeChartModel) GetAllForBrand(brandID int64) ([]*SizeChart, error) {
+	query := `
+		SELECT size_systems.id, size_systems.slug, brand_size_charts.size, brand_size_charts.measurements
+		FROM brand_size_charts INNER JOIN size_systems ON size_systems.id = brand_size_charts.size_system_id
+		WHERE brand_size_charts.brand_id = $1
+		ORDER BY size_systems.id, array_position(size_systems.sizes, brand_size_charts.size)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, brandID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	charts := []*SizeChart{}
+	for rows.Next() {
+		var systemID int64
+		var slug string
+		var row SizeChartRow
+		err := rows.Scan(&systemID, &slug, &row.Size, &row.Measurements)
+		if err != nil {
+			return nil, err
+		}
+		if len(charts) == 0 || charts[len(charts)-1].SizeSystemID != systemID {
+			charts = append(charts, &SizeChart{SizeSystemID: systemID, SizeSystem: slug})
+		}
+		chart := charts[len(charts)-1]
+		chart.Rows = append(chart.Rows, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return charts, nil
+}
+
+// Replace swaps the size chart of the brand in the size system for rows. No
+// rows removes the chart.
+func (m SizeChartModel) Replace(brandID, sizeSystemID int64, rows []SizeChartRow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM brand_size_charts WHERE brand_id = $1 AND size_system_id = $2`, brandID, sizeSystemID)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO brand_size_charts (brand_id, size_system_id, size, measurements)
+		VALUES ($1, $2, $3, $4)`
+	for _, row := range rows {
+		_, err = tx.ExecContext(ctx, query, brandID, sizeSystemID, row.Size, row.Measurements)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
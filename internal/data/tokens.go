@@ -13,14 +13,24 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeImpersonation  = "impersonation"
 )
 
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext      string    `json:"token"`
+	Hash           []byte    `json:"-"`
+	UserID         int64     `json:"-"`
+	Expiry         time.Time `json:"expiry"`
+	Scope          string    `json:"-"`
+	ImpersonatorID int64     `json:"impersonator_id,omitempty"`
+}
+
+// TokenMetadata describes a token without exposing anything that could be used to
+// authenticate with it.
+type TokenMetadata struct {
+	Scope          string    `json:"scope"`
+	Expiry         time.Time `json:"expiry"`
+	ImpersonatorID int64     `json:"impersonator_id,omitempty"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -58,11 +68,22 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+func (m TokenModel) NewImpersonation(userID, impersonatorID int64, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeImpersonation)
+	if err != nil {
+		return nil, err
+	}
+	token.ImpersonatorID = impersonatorID
+	err = m.Insert(token)
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+INSERT INTO tokens (hash, user_id, expiry, scope, impersonator_id)
+VALUES ($1, $2, $3, $4, $5)`
+	impersonatorID := sql.NullInt64{Int64: token.ImpersonatorID, Valid: token.ImpersonatorID != 0}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, impersonatorID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -78,3 +99,31 @@ WHERE scope = $1 AND user_id = $2`
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+func (m TokenModel) GetAllForUser(userID int64) ([]*TokenMetadata, error) {
+	query := `
+SELECT scope, expiry, COALESCE(impersonator_id, 0)
+FROM tokens
+WHERE user_id = $1
+ORDER BY expiry`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []*TokenMetadata{}
+	for rows.Next() {
+		var token TokenMetadata
+		err := rows.Scan(&token.Scope, &token.Expiry, &token.ImpersonatorID)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
@@ -0,0 +1,263 @@
+package data
+
+import (
+	"clothing-store/internal/i18n"
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// Translatable names the kind of record a translation belongs to.
+type Translatable string
+
+const (
+	TranslatableClothe   Translatable = "clothe"
+	TranslatableBrand    Translatable = "brand"
+	TranslatableCategory Translatable = "category"
+)
+
+// Translation holds the texts of a record in one locale other than the
+// default. An empty text is not translated and falls back to the next locale
+// of the chain.
+type Translation struct {
+	Locale      string `json:"locale"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func ValidateTranslation(v *validator.Validator, kind Translatable, translation *Translation) {
+	v.Check(i18n.Supported(translation.Locale) && translation.Locale != i18n.Default, "locale", "must be one of the supported locales")
+	v.Check(translation.Name != "" || translation.Description != "", "name", "must be provided")
+	v.Check(len(translation.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(translation.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+	if kind == TranslatableCategory {
+		v.Check(translation.Description == "", "description", "categories have no description")
+	}
+}
+
+type TranslationModel struct {
+	DB *sql.DB
+}
+
+// table returns the translation table of kind and its key column.
+func (kind Translatable) table() (string, string) {
+	switch kind {
+	case TranslatableClothe:
+		return "clothe_translations", "clothe_id"
+	case TranslatableBrand:
+		return "brand_translations", "brand_id"
+	case TranslatableCategory:
+		return "category_translations", "category_id"
+	}
+	panic("unknown translatable " + string(kind))
+}
+
+func (m TranslationModel) GetAll(kind Translatable, id int64) ([]*Translation, error) {
+	table, key := kind.table()
+	query := `
+		SELECT locale, name, description
+		FROM ` + table + `
+		WHERE ` + key + ` = $1
+		ORDER BY locale`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []*Translation{}
+	for rows.Next() {
+		var translation Translation
+		err := rows.Scan(&translation.Locale, &translation.Name, &translation.Description)
+		if err != nil {
+			return nil, err
+		}
+		translations = append(translations, &translation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return translations, nil
+}
+
+// Upsert adds the translation of the record, or replaces it.
+func (m TranslationModel) Upsert(kind Translatable, id int64, translation *Translation) error {
+	table, key := kind.table()
+	query := `
+		INSERT INTO ` + table + ` (` + key + `, locale, name, description)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (` + key + `, locale) DO UPDATE
+		SET name = EXCLUDED.name, description = EXCLUDED.description`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, translation.Locale, translation.Name, translation.Description)
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), `pq: insert or update on table "`+table+`" violates foreign key constraint`):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m TranslationModel) Delete(kind Translatable, id int64, locale string) error {
+	table, key := kind.table()
+	query := `
+		DELETE FROM ` + table + `
+		WHERE ` + key + ` = $1 AND locale = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, locale)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// lookup returns the texts of the records in locale, each text taken from the
+// first locale of the fallback chain that translates it. Records without any
+// translation are left out.
+func (m TranslationModel) lookup(kind Translatable, ids []int64, locale string) (map[int64]*Translation, error) {
+	translations := map[int64]*Translation{}
+	chain := i18n.Chain(locale)
+	// The main tables hold the default locale, nothing to look up.
+	if len(chain) == 1 || len(ids) == 0 {
+		return translations, nil
+	}
+	table, key := kind.table()
+	query := `
+		SELECT ` + key + `, name, description
+		FROM ` + table + `
+		WHERE ` + key + ` = ANY($1) AND locale = ANY($2)
+		ORDER BY array_position($2, locale)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), pq.Array(chain))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name, description string
+		err := rows.Scan(&id, &name, &description)
+		if err != nil {
+			return nil, err
+		}
+		translation, ok := translations[id]
+		if !ok {
+			translation = &Translation{Locale: locale}
+			translations[id] = translation
+		}
+		if translation.Name == "" {
+			translation.Name = name
+		}
+		if translation.Description == "" {
+			translation.Description = description
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return translations, nil
+}
+
+// LocalizeClothes shows the clothes, and their brand and category, in locale.
+func (m TranslationModel) LocalizeClothes(clothes []*Clothe, locale string) error {
+	var clotheIDs, brandIDs, categoryIDs []int64
+	for _, clothe := range clothes {
+		clotheIDs = append(clotheIDs, clothe.ID)
+		brandIDs = append(brandIDs, clothe.Brand.ID)
+		categoryIDs = append(categoryIDs, clothe.Category.ID)
+	}
+	clotheTranslations, err := m.lookup(TranslatableClothe, clotheIDs, locale)
+	if err != nil {
+		return err
+	}
+	brandTranslations, err := m.lookup(TranslatableBrand, brandIDs, locale)
+	if err != nil {
+		return err
+	}
+	categoryTranslations, err := m.lookup(TranslatableCategory, categoryIDs, locale)
+	if err != nil {
+		return err
+	}
+	for _, clothe := range clothes {
+		if t, ok := clotheTranslations[clothe.ID]; ok {
+			clothe.Name = translated(t.Name, clothe.Name)
+			clothe.Description = translated(t.Description, clothe.Description)
+		}
+		if t, ok := brandTranslations[clothe.Brand.ID]; ok {
+			clothe.Brand.Name = translated(t.Name, clothe.Brand.Name)
+		}
+		if t, ok := categoryTranslations[clothe.Category.ID]; ok {
+			clothe.Category.Name = translated(t.Name, clothe.Category.Name)
+		}
+	}
+	return nil
+}
+
+func (m TranslationModel) LocalizeBrands(brands []*Brand, locale string) error {
+	ids := make([]int64, len(brands))
+	for i, brand := range brands {
+		ids[i] = brand.ID
+	}
+	translations, err := m.lookup(TranslatableBrand, ids, locale)
+	if err != nil {
+		return err
+	}
+	for _, brand := range brands {
+		if t, ok := translations[brand.ID]; ok {
+			brand.Name = translated(t.Name, brand.Name)
+			brand.Description = translated(t.Description, brand.Description)
+		}
+	}
+	return nil
+}
+
+func (m TranslationModel) LocalizeCategories(categories []*Category, locale string) error {
+	ids := make([]int64, len(categories))
+	for i, category := range categories {
+		ids[i] = category.ID
+	}
+	translations, err := m.lookup(TranslatableCategory, ids, locale)
+	if err != nil {
+		return err
+	}
+	for _, category := range categories {
+		if t, ok := translations[category.ID]; ok {
+			category.Name = translated(t.Name, category.Name)
+		}
+	}
+	return nil
+}
+
+func translated(text, fallback string) string {
+	if text == "" {
+		return fallback
+	}
+	return text
+}
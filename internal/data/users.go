@@ -11,9 +11,13 @@ import (
 )
 
 type User struct {
-	ID        int64    `json:"id"`
-	Name      string   `json:"name"`
-	Money     int64    `json:"money"`
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Money int64  `json:"money"`
+	// Currency is the currency the wallet holds Money in, in minor units.
+	Currency string `json:"currency"`
+	// Locale is the locale emails are sent in.
+	Locale    string   `json:"locale"`
 	Email     string   `json:"email"`
 	Password  password `json:"-"`
 	Activated bool     `json:"activated"`
@@ -91,13 +95,13 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 func (m UserModel) Insert(user *User) error {
 	query := `
-				INSERT INTO users (name, money, email, password_hash, activated)
-				VALUES ($1, $2, $3, $4, $5)
-				RETURNING id, version`
-	args := []any{user.Name, user.Money, user.Email, user.Password.hash, user.Activated}
+				INSERT INTO users (name, money, email, password_hash, activated, currency, locale)
+				VALUES ($1, $2, $3, $4, $5, COALESCE(NULLIF($6, ''), (SELECT currency FROM exchange_rates WHERE base)), COALESCE(NULLIF($7, ''), 'en'))
+				RETURNING id, currency, locale, version`
+	args := []any{user.Name, user.Money, user.Email, user.Password.hash, user.Activated, user.Currency, user.Locale}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Currency, &user.Locale, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -111,7 +115,7 @@ func (m UserModel) Insert(user *User) error {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-				SELECT id, money, name, email, password_hash, activated, version
+				SELECT id, money, currency, locale, name, email, password_hash, activated, version
 				FROM users
 				WHERE email = $1`
 	var user User
@@ -120,6 +124,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Money,
+		&user.Currency,
+		&user.Locale,
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
@@ -174,7 +180,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-SELECT users.id, users.name, users.money, users.email, users.password_hash, users.activated, users.version
+SELECT users.id, users.name, users.money, users.currency, users.locale, users.email, users.password_hash, users.activated, users.version
 FROM users
 INNER JOIN tokens
 ON users.id = tokens.user_id
@@ -195,6 +201,8 @@ AND tokens.expiry > $3`
 		&user.ID,
 		&user.Name,
 		&user.Money,
+		&user.Currency,
+		&user.Locale,
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
@@ -212,6 +220,81 @@ AND tokens.expiry > $3`
 	return &user, nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+				SELECT id, money, currency, locale, name, email, password_hash, activated, version
+				FROM users
+				WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Money,
+		&user.Currency,
+		&user.Locale,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// GetForImpersonationToken returns the impersonated user together with the ID of
+// the admin who issued the token.
+func (m UserModel) GetForImpersonationToken(tokenPlaintext string) (*User, int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+SELECT users.id, users.name, users.money, users.currency, users.locale, users.email, users.password_hash, users.activated, users.version,
+       tokens.impersonator_id
+FROM users
+INNER JOIN tokens
+ON users.id = tokens.user_id
+WHERE tokens.hash = $1
+AND tokens.scope = $2
+AND tokens.expiry > $3
+AND tokens.impersonator_id IS NOT NULL`
+	args := []any{tokenHash[:], ScopeImpersonation, time.Now()}
+	var user User
+	var impersonatorID int64
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Money,
+		&user.Currency,
+		&user.Locale,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&impersonatorID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+	return &user, impersonatorID, nil
+}
+
 func (m UserModel) UpdateMoney(user *User, money int64) error {
 	newMoney := user.Money - money
 	if newMoney < 0 {
@@ -244,14 +327,29 @@ RETURNING version`
 	return nil
 }
 
-func (m UserModel) Delete(id int64) error {
+// Anonymize erases the personal data of a user while keeping the row, so that
+// carts and other financial records that reference it stay intact. Tokens are
+// removed as they are of no use once the account can no longer sign in, and so
+// are the wishlist and the stock alerts. Audit entries are kept without the
+// addresses they came from.
+func (m UserModel) Anonymize(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-				DELETE FROM users
-				WHERE id = $1`
-	result, err := m.DB.Exec(query, id)
+UPDATE users
+SET name = 'erased user', email = 'erased-' || id || '@erased.invalid', password_hash = '\x',
+    activated = false, erased_at = NOW(), version = version + 1
+WHERE id = $1 AND erased_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -262,5 +360,22 @@ func (m UserModel) Delete(id int64) error {
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
-	return nil
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM wishlist_items WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM alerts WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	// The audit entries are kept, without the addresses they came from.
+	_, err = tx.ExecContext(ctx, `UPDATE audit_entries SET remote_addr = '' WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
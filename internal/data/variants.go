@@ -0,0 +1,191 @@
+package data
+
+import (
+	"clothing-store/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+var ErrDuplicateColor = errors.New("duplicate color")
+
+// ClotheVariant is another color of a clothe. The clothe's own color and sizes
+// describe its default variant, so only the additional colors are stored as
+// variants. Price overrides the clothe's price when set.
+type ClotheVariant struct {
+	ID       int64         `json:"id"`
+	ClotheID int64         `json:"-"`
+	Color    string        `json:"color"`
+	Sizes    []string      `json:"sizes"`
+	Price    *int64        `json:"price,omitempty"`
+	Position int64         `json:"position"`
+	Images   []ClotheImage `json:"images,omitempty"`
+}
+
+// ValidateClotheVariant checks the variant; clotheColor is the color of the
+// default variant, which the variant must not repeat, and system the size
+// system of the clothe.
+func ValidateClotheVariant(v *validator.Validator, variant *ClotheVariant, clotheColor string, system *SizeSystem) {
+	v.Check(variant.Color != "", "color", "must be provided")
+	v.Check(!strings.EqualFold(variant.Color, clotheColor), "color", "must differ from the color of the clothe")
+	v.Check(variant.Sizes != nil, "sizes", "must be provided")
+	v.Check(len(variant.Sizes) >= 1, "sizes", "must contain at least 1 size")
+	v.Check(validator.Unique(variant.Sizes), "sizes", "must not contain duplicate values")
+	validateSizes(v, variant.Sizes, system)
+	if variant.Price != nil {
+		v.Check(*variant.Price > 0, "price", "must be a positive integer")
+	}
+	v.Check(variant.Position >= 0, "position", "must be zero or a positive integer")
+}
+
+type VariantModel struct {
+	DB *sql.DB
+}
+
+func (m VariantModel) Insert(variant *ClotheVariant) error {
+	query := `
+		INSERT INTO clothe_variants (clothe_id, color, sizes, price, position)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+	args := []any{variant.ClotheID, variant.Color, pq.Array(variant.Sizes), variant.Price, variant.Position}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&variant.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "clothe_variants_color_idx"`:
+			return ErrDuplicateColor
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m VariantModel) Get(clotheID, id int64) (*ClotheVariant, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, clothe_id, color, sizes, price, position
+		FROM clothe_variants
+		WHERE clothe_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	variant, err := scanClotheVariant(m.DB.QueryRowContext(ctx, query, clotheID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return variant, nil
+}
+
+// GetAllForClothes returns the variants of the given clothes keyed by clothe
+// id, which lets a listing load the variants of a whole page at once.
+func (m VariantModel) GetAllForClothes(clotheIDs []int64) (map[int64][]ClotheVariant, error) {
+	query := `
+		SELECT id, clothe_id, color, sizes, price, position
+		FROM clothe_variants
+		WHERE clothe_id = ANY($1)
+		ORDER BY position, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(clotheIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make(map[int64][]ClotheVariant)
+	for rows.Next() {
+		variant, err := scanClotheVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+		variants[variant.ClotheID] = append(variants[variant.ClotheID], *variant)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+func (m VariantModel) Update(variant *ClotheVariant) error {
+	query := `
+		UPDATE clothe_variants
+		SET color = $1, sizes = $2, price = $3, position = $4
+		WHERE clothe_id = $5 AND id = $6
+		RETURNING id`
+	args := []any{variant.Color, pq.Array(variant.Sizes), variant.Price, variant.Position, variant.ClotheID, variant.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&variant.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "clothe_variants_color_idx"`:
+			return ErrDuplicateColor
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the variant. Its images stay in the gallery of the clothe.
+func (m VariantModel) Delete(clotheID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM clothe_variants
+		WHERE clothe_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, clotheID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func scanClotheVariant(row rowScanner) (*ClotheVariant, error) {
+	var variant ClotheVariant
+	err := row.Scan(
+		&variant.ID,
+		&variant.ClotheID,
+		&variant.Color,
+		pq.Array(&variant.Sizes),
+		&variant.Price,
+		&variant.Position,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &variant, nil
+}
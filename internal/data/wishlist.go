@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// WishlistItem is a clothe saved by a user. There is no inventory count, a
+// clothe is in stock while it is on sale and AvailableSizes lists the sizes
+// offered by any of its variants.
+type WishlistItem struct {
+	Clothe         *Clothe   `json:"clothe"`
+	EffectivePrice int64     `json:"effective_price"`
+	InStock        bool      `json:"in_stock"`
+	AvailableSizes []string  `json:"available_sizes"`
+	AddedAt        time.Time `json:"added_at"`
+}
+
+// WishlistCount is a row of the most wishlisted report.
+type WishlistCount struct {
+	ClotheID int64  `json:"clothe_id"`
+	Name     string `json:"name"`
+	Count    int64  `json:"count"`
+}
+
+type WishlistModel struct {
+	DB *sql.DB
+}
+
+// Add saves the clothe to the wishlist of the user, saving it twice is not an
+// error.
+func (m WishlistModel) Add(userID, clotheID int64) error {
+	query := `
+		INSERT INTO wishlist_items (user_id, clothe_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, clotheID)
+	return err
+}
+
+func (m WishlistModel) Remove(userID, clotheID int64) error {
+	query := `
+		DELETE FROM wishlist_items
+		WHERE user_id = $1 AND clothe_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, clotheID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// wishlistSortColumns maps the sort keys of the wishlist onto the expressions
+// they order by.
+var wishlistSortColumns = map[string]string{
+	"added_at": "wishlist_items.created_at",
+	"id":       "clothes.id",
+}
+
+// GetAll returns a page of the wishlist of the user. Archived clothes are kept
+// in the list and reported as out of stock.
+func (m WishlistModel) GetAll(userID int64, filters Filters) ([]*WishlistItem, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), wishlist_items.created_at,
+			(SELECT MIN(variant.price) FROM %s AS variant),
+			ARRAY(SELECT DISTINCT unnest(variant.sizes) FROM %s AS variant ORDER BY 1),
+			%s
+		FROM wishlist_items INNER JOIN %s ON clothes.id = wishlist_items.clothe_id
+		WHERE wishlist_items.user_id = $1
+		ORDER BY %s %s, clothes.id ASC
+		LIMIT $2 OFFSET $3`, clotheVariantSet, clotheVariantSet, clotheColumns, clotheJoins,
+		wishlistSortColumns[filters.sortColumn()], filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := int64(0)
+	items := []*WishlistItem{}
+	for rows.Next() {
+		var item WishlistItem
+		item.Clothe, err = scanClothe(leadingScanner{
+			rows:    rows,
+			leading: []any{&totalRecords, &item.AddedAt, &item.EffectivePrice, pq.Array(&item.AvailableSizes)},
+		})
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		item.InStock = item.Clothe.DeletedAt == nil && len(item.AvailableSizes) > 0
+		items = append(items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return items, metadata, nil
+}
+
+// mostWishlistedSortColumns maps the sort keys of the most wishlisted report
+// onto the expressions they order by.
+var mostWishlistedSortColumns = map[string]string{
+	"count": "COUNT(wishlist_items.user_id)",
+	"id":    "clothes.id",
+}
+
+// MostWishlisted counts the wishlists every clothe is saved in and returns a
+// page of the report.
+func (m WishlistModel) MostWishlisted(filters Filters) ([]*WishlistCount, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), clothes.id, clothes.name, COUNT(wishlist_items.user_id)
+		FROM wishlist_items INNER JOIN clothes ON clothes.id = wishlist_items.clothe_id
+		GROUP BY clothes.id
+		ORDER BY %s %s, clothes.id ASC
+		LIMIT $1 OFFSET $2`, mostWishlistedSortColumns[filters.sortColumn()], filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := int64(0)
+	counts := []*WishlistCount{}
+	for rows.Next() {
+		var count WishlistCount
+		err := rows.Scan(&totalRecords, &count.ClotheID, &count.Name, &count.Count)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		counts = append(counts, &count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return counts, metadata, nil
+}
+
+// leadingScanner reads extra columns that precede the regular columns of a
+// query.
+type leadingScanner struct {
+	rows    *sql.Rows
+	leading []any
+}
+
+func (s leadingScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(append([]any{}, s.leading...), dest...)...)
+}
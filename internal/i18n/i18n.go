@@ -0,0 +1,136 @@
+// Package i18n picks the locale of a request and translates API messages.
+//
+// Messages are looked up by their English text in the catalogues of the
+// catalogues directory, one JSON object per locale. A message missing from a
+// catalogue is looked up in the next locale of the fallback chain and is left
+// in English at the end of it.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the locale of the content kept in the main tables and of the
+// messages in the code.
+const Default = "en"
+
+// Locales lists the supported locales.
+var Locales = []string{"en", "ru", "kk"}
+
+// fallbacks gives the locale to try when a text is missing in a locale. Most
+// of our Kazakh speaking customers read Russian too, so Kazakh falls back to
+// Russian before English.
+var fallbacks = map[string]string{
+	"kk": "ru",
+	"ru": Default,
+}
+
+//go:embed "catalogues"
+var catalogueFS embed.FS
+
+// Catalogues holds the catalogue of every locale but Default, keyed by locale.
+type Catalogues map[string]map[string]string
+
+var catalogues = Catalogues{}
+
+func init() {
+	for _, locale := range Locales {
+		if locale == Default {
+			continue
+		}
+		b, err := catalogueFS.ReadFile("catalogues/" + locale + ".json")
+		if err != nil {
+			panic(err)
+		}
+		catalogue := map[string]string{}
+		err = json.Unmarshal(b, &catalogue)
+		if err != nil {
+			panic("catalogue " + locale + ": " + err.Error())
+		}
+		catalogues[locale] = catalogue
+	}
+}
+
+// Supported reports whether locale is one of Locales.
+func Supported(locale string) bool {
+	for _, l := range Locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain returns locale followed by the locales it falls back to, ending with
+// Default. An unsupported locale gives just Default.
+func Chain(locale string) []string {
+	if !Supported(locale) {
+		return []string{Default}
+	}
+	chain := []string{locale}
+	for locale != Default {
+		locale = fallbacks[locale]
+		chain = append(chain, locale)
+	}
+	return chain
+}
+
+// Negotiate picks the supported locale the Accept-Language header prefers. A
+// regional tag such as ru-KZ matches its language. Default is returned when
+// nothing matches.
+func Negotiate(acceptLanguage string) string {
+	type tag struct {
+		locale string
+		q      float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		locale, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		locale, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(locale)), "-")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 && Supported(locale) {
+			tags = append(tags, tag{locale: locale, q: q})
+		}
+	}
+	if len(tags) == 0 {
+		return Default
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+	return tags[0].locale
+}
+
+// Has reports whether the catalogue of locale translates message, without
+// following the fallback chain.
+func Has(locale, message string) bool {
+	_, ok := catalogues[locale][message]
+	return ok
+}
+
+// Translate returns message in locale, following the fallback chain.
+func Translate(locale, message string) string {
+	return catalogues.Translate(locale, message)
+}
+
+// Translate returns message in locale from the catalogues c, following the
+// fallback chain.
+func (c Catalogues) Translate(locale, message string) string {
+	for _, l := range Chain(locale) {
+		if translated, ok := c[l][message]; ok {
+			return translated
+		}
+	}
+	return message
+}
@@ -2,9 +2,11 @@ package mailer
 
 import (
 	"bytes"
+	"clothing-store/internal/i18n"
 	"embed"
 	"github.com/go-mail/mail/v2"
 	"html/template"
+	"io/fs"
 	"time"
 )
 
@@ -25,8 +27,11 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data any) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// Send sends the email in locale. The template is taken from the directory of
+// the first locale of the fallback chain that has it, templates/ru for
+// Russian, and from templates itself for the default locale.
+func (m Mailer) Send(recipient, locale, templateFile string, data any) error {
+	tmpl, err := template.New("email").ParseFS(templateFS, templatePath(locale, templateFile))
 	if err != nil {
 		return err
 	}
@@ -57,3 +62,16 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	}
 	return nil
 }
+
+func templatePath(locale, templateFile string) string {
+	for _, l := range i18n.Chain(locale) {
+		if l == i18n.Default {
+			break
+		}
+		path := "templates/" + l + "/" + templateFile
+		if _, err := fs.Stat(templateFS, path); err == nil {
+			return path
+		}
+	}
+	return "templates/" + templateFile
+}
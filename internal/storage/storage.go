@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage keeps uploaded files. Keys are slash separated relative paths such as
+// "clothes/12/3f9a.jpg", Put returns the public URL of the stored file.
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Local writes files below Dir. They are expected to be served by the API
+// itself, BaseURL is the path or URL that Dir is mounted at.
+type Local struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocal(dir, baseURL string) Local {
+	return Local{
+		Dir:     dir,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// MountPath returns the path Dir has to be served at, the path of BaseURL
+// when that is a full URL. It is empty when the files are served at the root
+// of another host.
+func (s Local) MountPath() string {
+	u, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return s.BaseURL
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+func (s Local) Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error) {
+	filename, err := s.filename(key)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0o755)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, body)
+	if err != nil {
+		file.Close()
+		os.Remove(filename)
+		return "", err
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(filename)
+		return "", err
+	}
+	return s.BaseURL + "/" + key, nil
+}
+
+func (s Local) Delete(ctx context.Context, key string) error {
+	filename, err := s.filename(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// validKey refuses keys that would escape the storage root.
+func validKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, "/") && path.Clean(key) == key && !strings.HasPrefix(key, "..")
+}
+
+func (s Local) filename(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,156 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"path"
+	"strings"
+	// Register the decoders of the accepted upload formats.
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Widths are the widths of the generated variants: a thumbnail for listings
+// and a medium and large version for product pages.
+var Widths = []int{160, 640, 1280}
+
+// MaxDimension and MaxPixels bound the images Generate accepts. Decoding
+// allocates width*height pixels, so the bounds are checked on the header
+// before the image itself is decoded.
+const (
+	MaxDimension = 10000
+	MaxPixels    = 40_000_000
+)
+
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+const quality = 82
+
+// Format is the encoding of a variant.
+type Format string
+
+const (
+	JPEG Format = "jpeg"
+	WebP Format = "webp"
+)
+
+// Formats lists the formats every variant is generated in. JPEG variants are
+// lossy, WebP ones lossless since there is no pure Go lossy WebP encoder.
+var Formats = []Format{JPEG, WebP}
+
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+func (f Format) ext() string {
+	if f == JPEG {
+		return ".jpg"
+	}
+	return "." + string(f)
+}
+
+// Variant is a resized copy of an image.
+type Variant struct {
+	Width  int
+	Format Format
+	Data   []byte
+}
+
+// Descriptor is the srcset width descriptor of the variant, e.g. "640w".
+func (v Variant) Descriptor() string {
+	return fmt.Sprintf("%dw", v.Width)
+}
+
+// SrcsetKey names the variant in a srcset map: the width descriptor for JPEG
+// variants and the descriptor followed by the format for the others, e.g.
+// "640w" and "640w.webp".
+func (v Variant) SrcsetKey() string {
+	if v.Format == JPEG {
+		return v.Descriptor()
+	}
+	return v.Descriptor() + "." + string(v.Format)
+}
+
+// Key derives the storage key of a variant from the key of the original, so
+// that the variants can be found again when the original is deleted.
+func Key(original string, width int, format Format) string {
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(original, path.Ext(original)), width, format.ext())
+}
+
+// Keys lists the storage keys every variant of the original may have.
+func Keys(original string) []string {
+	var keys []string
+	for _, width := range Widths {
+		for _, format := range Formats {
+			keys = append(keys, Key(original, width, format))
+		}
+	}
+	return keys
+}
+
+// CheckSize reads the dimensions from the image header and returns
+// ErrTooLarge when they exceed MaxDimension or MaxPixels.
+func CheckSize(original []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(original))
+	if err != nil {
+		return err
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension || config.Width*config.Height > MaxPixels {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// Generate decodes a JPEG, PNG, GIF or WebP image and returns a variant in
+// every format of Formats for every width in Widths that is smaller than the
+// image itself. Images are never upscaled, the variant list is empty for
+// images that are already small.
+func Generate(original []byte) ([]Variant, error) {
+	err := CheckSize(original)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+
+	var variants []Variant
+	for _, width := range Widths {
+		if width >= bounds.Dx() {
+			break
+		}
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		// JPEG has no alpha channel, transparent areas become white instead
+		// of black. WebP gets the same background so both formats look alike.
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+		for _, format := range Formats {
+			var buf bytes.Buffer
+			switch format {
+			case JPEG:
+				err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality})
+			case WebP:
+				err = nativewebp.Encode(&buf, dst, nil)
+			}
+			if err != nil {
+				return nil, err
+			}
+			variants = append(variants, Variant{Width: width, Format: format, Data: buf.Bytes()})
+		}
+	}
+	return variants, nil
+}
@@ -0,0 +1,2 @@
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS impersonator_id bigint REFERENCES users ON DELETE CASCADE;
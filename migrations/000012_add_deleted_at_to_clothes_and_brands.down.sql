@@ -0,0 +1,4 @@
+ALTER TABLE clothes
+    DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE brands
+    DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,4 @@
+ALTER TABLE clothes
+    ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE brands
+    ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
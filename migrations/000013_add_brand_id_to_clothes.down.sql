@@ -0,0 +1,14 @@
+ALTER TABLE clothes
+    ADD COLUMN IF NOT EXISTS brand text NOT NULL DEFAULT '';
+
+UPDATE clothes
+SET brand = brands.name
+FROM brands
+WHERE brands.id = clothes.brand_id;
+
+ALTER TABLE clothes
+    ALTER COLUMN brand DROP DEFAULT;
+
+DROP INDEX IF EXISTS clothes_brand_id_idx;
+ALTER TABLE clothes
+    DROP COLUMN IF EXISTS brand_id;
@@ -0,0 +1,19 @@
+-- Every free-text brand that is not a known brand yet becomes one, so that no
+-- clothe is left without a reference.
+INSERT INTO brands (name, country, description, image_url)
+SELECT DISTINCT ON (lower(clothes.brand)) clothes.brand, '', '', ''
+FROM clothes
+WHERE NOT EXISTS (SELECT 1 FROM brands WHERE lower(brands.name) = lower(clothes.brand));
+
+ALTER TABLE clothes
+    ADD COLUMN IF NOT EXISTS brand_id bigint REFERENCES brands ON DELETE RESTRICT;
+
+UPDATE clothes
+SET brand_id = (SELECT brands.id FROM brands WHERE lower(brands.name) = lower(clothes.brand) ORDER BY brands.id LIMIT 1);
+
+ALTER TABLE clothes
+    ALTER COLUMN brand_id SET NOT NULL;
+ALTER TABLE clothes
+    DROP COLUMN IF EXISTS brand;
+
+CREATE INDEX IF NOT EXISTS clothes_brand_id_idx ON clothes (brand_id);
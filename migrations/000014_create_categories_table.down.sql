@@ -0,0 +1,16 @@
+ALTER TABLE clothes
+    ADD COLUMN IF NOT EXISTS type text NOT NULL DEFAULT '';
+
+UPDATE clothes
+SET type = categories.name
+FROM categories
+WHERE categories.id = clothes.category_id;
+
+ALTER TABLE clothes
+    ALTER COLUMN type DROP DEFAULT;
+
+DROP INDEX IF EXISTS clothes_category_id_idx;
+ALTER TABLE clothes
+    DROP COLUMN IF EXISTS category_id;
+
+DROP TABLE IF EXISTS categories;
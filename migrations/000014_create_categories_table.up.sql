@@ -0,0 +1,95 @@
+CREATE TABLE IF NOT EXISTS categories (
+                                          id bigserial PRIMARY KEY,
+                                          parent_id bigint REFERENCES categories ON DELETE RESTRICT,
+                                          name text NOT NULL,
+                                          slug text UNIQUE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+-- Seed the tree: Men/Women/Unisex > group > leaf, e.g. Men > Tops > T-Shirts.
+INSERT INTO categories (name, slug)
+VALUES
+    ('Men', 'men'),
+    ('Women', 'women'),
+    ('Unisex', 'unisex');
+
+INSERT INTO categories (parent_id, name, slug)
+SELECT roots.id, groups.name, roots.slug || '-' || groups.slug
+FROM categories roots
+CROSS JOIN (VALUES
+    ('Tops', 'tops'),
+    ('Bottoms', 'bottoms'),
+    ('Outerwear', 'outerwear'),
+    ('Footwear', 'footwear')) AS groups(name, slug)
+WHERE roots.parent_id IS NULL;
+
+INSERT INTO categories (parent_id, name, slug)
+SELECT parents.id, leaves.name, parents.slug || '-' || leaves.slug
+FROM categories parents
+INNER JOIN categories roots ON roots.id = parents.parent_id AND roots.parent_id IS NULL
+INNER JOIN (VALUES
+    ('Tops', 'T-Shirts', 't-shirts'),
+    ('Tops', 'Shirts', 'shirts'),
+    ('Tops', 'Hoodies', 'hoodies'),
+    ('Tops', 'Sweaters', 'sweaters'),
+    ('Bottoms', 'Jeans', 'jeans'),
+    ('Bottoms', 'Trousers', 'trousers'),
+    ('Bottoms', 'Shorts', 'shorts'),
+    ('Bottoms', 'Skirts', 'skirts'),
+    ('Outerwear', 'Jackets', 'jackets'),
+    ('Outerwear', 'Coats', 'coats'),
+    ('Footwear', 'Sneakers', 'sneakers'),
+    ('Footwear', 'Boots', 'boots')) AS leaves(parent, name, slug) ON leaves.parent = parents.name;
+
+-- Normalise sex to the root slugs and map every known spelling of a type onto
+-- its leaf. Types that cannot be mapped land on the root category.
+UPDATE clothes
+SET sex = CASE
+              WHEN lower(sex) IN ('men', 'man', 'male', 'm') THEN 'men'
+              WHEN lower(sex) IN ('women', 'woman', 'female', 'f', 'w') THEN 'women'
+              ELSE 'unisex'
+    END;
+
+ALTER TABLE clothes
+    ADD COLUMN IF NOT EXISTS category_id bigint REFERENCES categories ON DELETE RESTRICT;
+
+UPDATE clothes
+SET category_id = categories.id
+FROM categories
+WHERE categories.slug = clothes.sex || COALESCE('-' || (
+    SELECT aliases.path
+    FROM (VALUES
+        ('tshirt', 'tops-t-shirts'),
+        ('tshirts', 'tops-t-shirts'),
+        ('tee', 'tops-t-shirts'),
+        ('tees', 'tops-t-shirts'),
+        ('shirt', 'tops-shirts'),
+        ('shirts', 'tops-shirts'),
+        ('hoodie', 'tops-hoodies'),
+        ('hoodies', 'tops-hoodies'),
+        ('hoody', 'tops-hoodies'),
+        ('sweater', 'tops-sweaters'),
+        ('sweaters', 'tops-sweaters'),
+        ('sweatshirt', 'tops-sweaters'),
+        ('jeans', 'bottoms-jeans'),
+        ('trousers', 'bottoms-trousers'),
+        ('pants', 'bottoms-trousers'),
+        ('shorts', 'bottoms-shorts'),
+        ('skirt', 'bottoms-skirts'),
+        ('skirts', 'bottoms-skirts'),
+        ('jacket', 'outerwear-jackets'),
+        ('jackets', 'outerwear-jackets'),
+        ('coat', 'outerwear-coats'),
+        ('coats', 'outerwear-coats'),
+        ('sneaker', 'footwear-sneakers'),
+        ('sneakers', 'footwear-sneakers'),
+        ('boot', 'footwear-boots'),
+        ('boots', 'footwear-boots')) AS aliases(alias, path)
+    WHERE aliases.alias = regexp_replace(lower(clothes.type), '[^a-z]', '', 'g')), '');
+
+ALTER TABLE clothes
+    ALTER COLUMN category_id SET NOT NULL;
+ALTER TABLE clothes
+    DROP COLUMN IF EXISTS type;
+
+CREATE INDEX IF NOT EXISTS clothes_category_id_idx ON clothes (category_id);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS brands_name_trgm_idx;
+DROP INDEX IF EXISTS brands_name_search_idx;
+DROP INDEX IF EXISTS clothes_color_trgm_idx;
+DROP INDEX IF EXISTS clothes_name_trgm_idx;
+DROP INDEX IF EXISTS clothes_search_idx;
+CREATE INDEX IF NOT EXISTS clothes_name_idx ON clothes USING GIN (to_tsvector('simple', name));
+
+ALTER TABLE clothes
+    DROP COLUMN IF EXISTS description;
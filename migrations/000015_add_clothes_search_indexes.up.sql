@@ -0,0 +1,12 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE clothes
+    ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS clothes_name_idx;
+CREATE INDEX IF NOT EXISTS clothes_search_idx ON clothes
+    USING GIN (to_tsvector('simple', name || ' ' || color || ' ' || description));
+CREATE INDEX IF NOT EXISTS clothes_name_trgm_idx ON clothes USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS clothes_color_trgm_idx ON clothes USING GIN (color gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS brands_name_search_idx ON brands USING GIN (to_tsvector('simple', name));
+CREATE INDEX IF NOT EXISTS brands_name_trgm_idx ON brands USING GIN (name gin_trgm_ops);
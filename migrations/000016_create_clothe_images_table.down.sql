@@ -0,0 +1,2 @@
+ALTER TABLE clothes ALTER COLUMN image_url DROP DEFAULT;
+DROP TABLE IF EXISTS clothe_images;
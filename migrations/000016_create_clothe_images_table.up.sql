@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS clothe_images (
+    id bigserial PRIMARY KEY,
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    storage_key text NOT NULL,
+    url text NOT NULL,
+    content_type text NOT NULL,
+    alt_text text NOT NULL DEFAULT '',
+    position integer NOT NULL DEFAULT 0,
+    is_primary boolean NOT NULL DEFAULT false,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS clothe_images_clothe_id_idx ON clothe_images (clothe_id, position);
+CREATE UNIQUE INDEX IF NOT EXISTS clothe_images_primary_idx ON clothe_images (clothe_id) WHERE is_primary;
+
+-- The image URL is filled in from the primary image now, clothes may be created
+-- before any image is uploaded.
+ALTER TABLE clothes ALTER COLUMN image_url SET DEFAULT '';
@@ -0,0 +1,5 @@
+ALTER TABLE brands
+    DROP COLUMN IF EXISTS image_variants,
+    DROP COLUMN IF EXISTS image_storage_key;
+ALTER TABLE clothe_images
+    DROP COLUMN IF EXISTS variants;
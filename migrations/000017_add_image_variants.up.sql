@@ -0,0 +1,5 @@
+ALTER TABLE clothe_images
+    ADD COLUMN IF NOT EXISTS variants jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE brands
+    ADD COLUMN IF NOT EXISTS image_storage_key text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS image_variants jsonb NOT NULL DEFAULT '{}';
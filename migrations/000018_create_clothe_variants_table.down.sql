@@ -0,0 +1,3 @@
+ALTER TABLE clothe_images
+    DROP COLUMN IF EXISTS variant_id;
+DROP TABLE IF EXISTS clothe_variants;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS clothe_variants (
+    id bigserial PRIMARY KEY,
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    color text NOT NULL,
+    sizes text[] NOT NULL,
+    price bigint CHECK (price > 0),
+    position integer NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS clothe_variants_clothe_id_idx ON clothe_variants (clothe_id, position);
+CREATE UNIQUE INDEX IF NOT EXISTS clothe_variants_color_idx ON clothe_variants (clothe_id, lower(color));
+
+ALTER TABLE clothe_images
+    ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES clothe_variants ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE clothes
+    DROP COLUMN IF EXISTS rating_count,
+    DROP COLUMN IF EXISTS rating_average;
+DROP TABLE IF EXISTS reviews;
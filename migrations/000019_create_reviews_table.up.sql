@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    title text NOT NULL,
+    body text NOT NULL,
+    fit text CHECK (fit IN ('runs_small', 'true_to_size', 'runs_large')),
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'published', 'rejected')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (clothe_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_clothe_id_idx ON reviews (clothe_id, status);
+CREATE INDEX IF NOT EXISTS reviews_status_idx ON reviews (status, created_at);
+
+-- Kept up to date with the published reviews so that listings can sort and
+-- filter on them.
+ALTER TABLE clothes
+    ADD COLUMN IF NOT EXISTS rating_average numeric(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS wishlist_items (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, clothe_id)
+);
+
+CREATE INDEX IF NOT EXISTS wishlist_items_clothe_id_idx ON wishlist_items (clothe_id);
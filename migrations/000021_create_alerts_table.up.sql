@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS alerts (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    kind text NOT NULL CHECK (kind IN ('back_in_stock', 'price_drop')),
+    -- The awaited size of a back_in_stock alert, empty for price drops.
+    size text NOT NULL DEFAULT '',
+    -- The price when a price_drop alert was created.
+    price bigint NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, clothe_id, kind, size)
+);
+
+CREATE INDEX IF NOT EXISTS alerts_clothe_id_idx ON alerts (clothe_id);
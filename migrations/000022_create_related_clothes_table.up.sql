@@ -0,0 +1,9 @@
+-- Precomputed recommendations, rebuilt periodically by the API server.
+CREATE TABLE IF NOT EXISTS related_clothes (
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    related_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    score double precision NOT NULL,
+    PRIMARY KEY (clothe_id, related_id)
+);
+
+CREATE INDEX IF NOT EXISTS related_clothes_score_idx ON related_clothes (clothe_id, score DESC);
@@ -0,0 +1,12 @@
+-- The size chosen for a clothe bought through the cart. Carts only keep the
+-- clothe ids, sizes are recorded here to recommend sizes later on.
+CREATE TABLE IF NOT EXISTS purchase_sizes (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    size text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS purchase_sizes_user_id_idx ON purchase_sizes (user_id);
+CREATE INDEX IF NOT EXISTS purchase_sizes_clothe_id_idx ON purchase_sizes (clothe_id);
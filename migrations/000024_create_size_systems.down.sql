@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS brand_size_charts;
+ALTER TABLE clothes DROP COLUMN IF EXISTS size_system_id;
+DROP TABLE IF EXISTS size_systems;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS size_systems (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    slug text UNIQUE NOT NULL,
+    -- Ordered from the smallest to the largest size.
+    sizes text[] NOT NULL
+);
+
+INSERT INTO size_systems (name, slug, sizes) VALUES
+    ('Alpha', 'alpha', '{XXS,XS,S,M,L,XL,XXL,XXXL}'),
+    ('EU shoe', 'eu-shoe', ARRAY(SELECT size::text FROM generate_series(35, 48) AS size)),
+    ('Waist/length', 'waist-length', ARRAY(
+        SELECT waist || '/' || length
+        FROM generate_series(26, 40, 2) AS waist, generate_series(28, 36, 2) AS length
+        ORDER BY waist, length)),
+    ('Kids height', 'kids', ARRAY(SELECT size::text FROM generate_series(86, 176, 6) AS size));
+
+ALTER TABLE clothes ADD COLUMN IF NOT EXISTS size_system_id bigint REFERENCES size_systems;
+UPDATE clothes SET size_system_id = (SELECT id FROM size_systems WHERE slug = 'alpha');
+ALTER TABLE clothes ALTER COLUMN size_system_id SET NOT NULL;
+
+-- Measurements in cm of every size of a brand, keyed by body part.
+CREATE TABLE IF NOT EXISTS brand_size_charts (
+    brand_id bigint NOT NULL REFERENCES brands ON DELETE CASCADE,
+    size_system_id bigint NOT NULL REFERENCES size_systems ON DELETE CASCADE,
+    size text NOT NULL,
+    measurements jsonb NOT NULL DEFAULT '{}',
+    PRIMARY KEY (brand_id, size_system_id, size)
+);
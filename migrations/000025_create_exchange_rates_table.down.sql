@@ -0,0 +1,9 @@
+UPDATE clothes SET price = price / 100;
+UPDATE clothe_variants SET price = price / 100 WHERE price IS NOT NULL;
+UPDATE alerts SET price = price / 100;
+UPDATE users SET money = money / 100;
+ALTER TABLE clothes ALTER COLUMN price TYPE integer;
+ALTER TABLE users ALTER COLUMN money TYPE integer;
+
+ALTER TABLE users DROP COLUMN IF EXISTS currency;
+DROP TABLE IF EXISTS exchange_rates;
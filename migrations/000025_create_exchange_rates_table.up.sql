@@ -0,0 +1,26 @@
+-- rate is the amount of the currency that one unit of the base currency buys.
+-- Clothe prices are kept in minor units of the base currency.
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    currency char(3) PRIMARY KEY CHECK (currency ~ '^[A-Z]{3}$'),
+    rate numeric(20, 10) NOT NULL CHECK (rate > 0),
+    minor_units smallint NOT NULL DEFAULT 2 CHECK (minor_units BETWEEN 0 AND 4),
+    base bool NOT NULL DEFAULT false,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CHECK (NOT base OR rate = 1)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS exchange_rates_base_idx ON exchange_rates (base) WHERE base;
+
+INSERT INTO exchange_rates (currency, rate, minor_units, base) VALUES ('USD', 1, 2, true);
+
+-- Wallets hold money in minor units of their own currency.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS currency char(3) NOT NULL DEFAULT 'USD' REFERENCES exchange_rates;
+
+-- Prices and money used to be whole units, they are rescaled to the minor
+-- units of the base currency.
+ALTER TABLE clothes ALTER COLUMN price TYPE bigint;
+ALTER TABLE users ALTER COLUMN money TYPE bigint;
+UPDATE clothes SET price = price * 100;
+UPDATE clothe_variants SET price = price * 100 WHERE price IS NOT NULL;
+UPDATE alerts SET price = price * 100;
+UPDATE users SET money = money * 100;
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+DROP TABLE IF EXISTS category_translations;
+DROP TABLE IF EXISTS brand_translations;
+DROP TABLE IF EXISTS clothe_translations;
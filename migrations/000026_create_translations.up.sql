@@ -0,0 +1,28 @@
+-- The main tables hold the English content. A translation holds the texts of
+-- one other locale, an empty text falls back to the next locale.
+CREATE TABLE IF NOT EXISTS clothe_translations (
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    locale text NOT NULL,
+    name text NOT NULL DEFAULT '',
+    description text NOT NULL DEFAULT '',
+    PRIMARY KEY (clothe_id, locale)
+);
+
+CREATE TABLE IF NOT EXISTS brand_translations (
+    brand_id bigint NOT NULL REFERENCES brands ON DELETE CASCADE,
+    locale text NOT NULL,
+    name text NOT NULL DEFAULT '',
+    description text NOT NULL DEFAULT '',
+    PRIMARY KEY (brand_id, locale)
+);
+
+CREATE TABLE IF NOT EXISTS category_translations (
+    category_id bigint NOT NULL REFERENCES categories ON DELETE CASCADE,
+    locale text NOT NULL,
+    name text NOT NULL DEFAULT '',
+    description text NOT NULL DEFAULT '',
+    PRIMARY KEY (category_id, locale)
+);
+
+-- The locale emails are sent in.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT 'en';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bundle_clothes;
+DROP TABLE IF EXISTS bundles;
@@ -0,0 +1,21 @@
+-- A bundle is sold either at a fixed price or at a percentage off the sum of
+-- the prices of its clothes.
+CREATE TABLE IF NOT EXISTS bundles (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    price bigint CHECK (price > 0),
+    percent_off integer CHECK (percent_off BETWEEN 1 AND 90),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    CHECK ((price IS NULL) <> (percent_off IS NULL))
+);
+
+CREATE TABLE IF NOT EXISTS bundle_clothes (
+    bundle_id bigint NOT NULL REFERENCES bundles ON DELETE CASCADE,
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (bundle_id, clothe_id)
+);
+
+CREATE INDEX IF NOT EXISTS bundle_clothes_clothe_id_idx ON bundle_clothes (clothe_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS collection_clothes;
+DROP TABLE IF EXISTS collections;
+DROP INDEX IF EXISTS clothes_tags_idx;
+ALTER TABLE clothes DROP COLUMN IF EXISTS tags;
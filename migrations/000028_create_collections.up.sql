@@ -0,0 +1,28 @@
+-- Free-form tags, kept lowercase.
+ALTER TABLE clothes ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS clothes_tags_idx ON clothes USING GIN (tags);
+
+-- A collection is shown between starts_at and ends_at, a missing bound leaves
+-- that side open.
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    slug text UNIQUE NOT NULL,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    hero_image_url text NOT NULL DEFAULT '',
+    hero_image_storage_key text NOT NULL DEFAULT '',
+    hero_image_variants jsonb NOT NULL DEFAULT '{}',
+    starts_at timestamp(0) with time zone,
+    ends_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1,
+    CHECK (starts_at IS NULL OR ends_at IS NULL OR starts_at < ends_at)
+);
+
+CREATE TABLE IF NOT EXISTS collection_clothes (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    clothe_id bigint NOT NULL REFERENCES clothes ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (collection_id, clothe_id)
+);
+
+CREATE INDEX IF NOT EXISTS collection_clothes_clothe_id_idx ON collection_clothes (clothe_id);
@@ -0,0 +1,2 @@
+-- The merge cannot be undone: the merged rows are archived and their images
+-- moved to the variants, which are edited like any other variant afterwards.
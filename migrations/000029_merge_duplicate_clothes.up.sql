@@ -0,0 +1,159 @@
+-- Before variants existed every color of a clothe was its own row. Rows of the
+-- same brand, category, sex, size system and name are merged into the oldest
+-- one: the first row of each new color becomes a variant, rows of a color the
+-- parent or one of its variants already has add their sizes to it. Every merged
+-- row is archived and the rows referring to it are moved to the parent.
+CREATE TEMPORARY TABLE clothe_duplicates AS
+SELECT duplicate.id, duplicate.parent_id, duplicate.color, duplicate.sizes,
+       NULLIF(duplicate.price, parent.price) AS price,
+       duplicate.color_rank = 1
+           AND lower(duplicate.color) <> lower(parent.color)
+           AND NOT EXISTS (
+               SELECT 1 FROM clothe_variants
+               WHERE clothe_variants.clothe_id = parent.id AND lower(clothe_variants.color) = lower(duplicate.color)
+           ) AS new_variant
+FROM (
+    SELECT id, color, sizes, price,
+           first_value(id) OVER (PARTITION BY brand_id, category_id, sex, size_system_id, lower(name) ORDER BY id) AS parent_id,
+           row_number() OVER (PARTITION BY brand_id, category_id, sex, size_system_id, lower(name), lower(color) ORDER BY id) AS color_rank
+    FROM clothes
+    WHERE deleted_at IS NULL
+) AS duplicate
+INNER JOIN clothes AS parent ON parent.id = duplicate.parent_id
+WHERE duplicate.id <> duplicate.parent_id;
+
+INSERT INTO clothe_variants (clothe_id, color, sizes, price, position)
+SELECT parent_id, color, sizes, price,
+       row_number() OVER (PARTITION BY parent_id ORDER BY id)
+           + (SELECT COALESCE(MAX(position), 0) FROM clothe_variants WHERE clothe_id = parent_id)
+FROM clothe_duplicates
+WHERE new_variant;
+
+-- Sizes of rows of an existing color are appended to the parent or the variant
+-- of that color, keeping the order of the sizes already there.
+UPDATE clothes
+SET sizes = clothes.sizes || ARRAY(
+    SELECT size
+    FROM clothe_duplicates CROSS JOIN LATERAL unnest(clothe_duplicates.sizes) WITH ORDINALITY AS sizes(size, n)
+    WHERE clothe_duplicates.parent_id = clothes.id AND lower(clothe_duplicates.color) = lower(clothes.color)
+    AND size <> ALL(clothes.sizes)
+    GROUP BY size
+    ORDER BY MIN(clothe_duplicates.id), MIN(n)
+)
+WHERE clothes.id IN (SELECT parent_id FROM clothe_duplicates);
+
+UPDATE clothe_variants
+SET sizes = clothe_variants.sizes || ARRAY(
+    SELECT size
+    FROM clothe_duplicates CROSS JOIN LATERAL unnest(clothe_duplicates.sizes) WITH ORDINALITY AS sizes(size, n)
+    WHERE clothe_duplicates.parent_id = clothe_variants.clothe_id
+    AND lower(clothe_duplicates.color) = lower(clothe_variants.color)
+    AND size <> ALL(clothe_variants.sizes)
+    GROUP BY size
+    ORDER BY MIN(clothe_duplicates.id), MIN(n)
+)
+WHERE clothe_variants.clothe_id IN (SELECT parent_id FROM clothe_duplicates);
+
+-- Images of a row of the color of the parent become images of the parent
+-- itself, the others images of the variant of their color.
+UPDATE clothe_images
+SET clothe_id = clothe_duplicates.parent_id, variant_id = clothe_variants.id, is_primary = false
+FROM clothe_duplicates
+LEFT JOIN clothe_variants ON clothe_variants.clothe_id = clothe_duplicates.parent_id
+    AND lower(clothe_variants.color) = lower(clothe_duplicates.color)
+WHERE clothe_images.clothe_id = clothe_duplicates.id;
+
+INSERT INTO wishlist_items (user_id, clothe_id, created_at)
+SELECT wishlist_items.user_id, clothe_duplicates.parent_id, wishlist_items.created_at
+FROM wishlist_items
+INNER JOIN clothe_duplicates ON clothe_duplicates.id = wishlist_items.clothe_id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM wishlist_items
+USING clothe_duplicates
+WHERE wishlist_items.clothe_id = clothe_duplicates.id;
+
+INSERT INTO alerts (user_id, clothe_id, kind, size, price, created_at)
+SELECT alerts.user_id, clothe_duplicates.parent_id, alerts.kind, alerts.size, alerts.price, alerts.created_at
+FROM alerts
+INNER JOIN clothe_duplicates ON clothe_duplicates.id = alerts.clothe_id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM alerts
+USING clothe_duplicates
+WHERE alerts.clothe_id = clothe_duplicates.id;
+
+-- A user reviews a clothe once. Of the reviews a user wrote of the merged rows
+-- the newest one moves to the parent unless the user reviewed the parent too,
+-- the others stay with the archived rows.
+UPDATE reviews
+SET clothe_id = moved.parent_id
+FROM (
+    SELECT DISTINCT ON (reviews.user_id, clothe_duplicates.parent_id) reviews.id, clothe_duplicates.parent_id
+    FROM reviews
+    INNER JOIN clothe_duplicates ON clothe_duplicates.id = reviews.clothe_id
+    WHERE NOT EXISTS (
+        SELECT 1 FROM reviews AS parent_reviews
+        WHERE parent_reviews.clothe_id = clothe_duplicates.parent_id AND parent_reviews.user_id = reviews.user_id
+    )
+    ORDER BY reviews.user_id, clothe_duplicates.parent_id, reviews.created_at DESC, reviews.id DESC
+) AS moved
+WHERE reviews.id = moved.id;
+
+UPDATE clothes
+SET (rating_average, rating_count) = (
+    SELECT COALESCE(AVG(rating), 0), COUNT(*)
+    FROM reviews
+    WHERE reviews.clothe_id = clothes.id AND reviews.status = 'published'
+)
+WHERE clothes.id IN (SELECT parent_id FROM clothe_duplicates UNION SELECT id FROM clothe_duplicates);
+
+UPDATE purchase_sizes
+SET clothe_id = clothe_duplicates.parent_id
+FROM clothe_duplicates
+WHERE purchase_sizes.clothe_id = clothe_duplicates.id;
+
+INSERT INTO bundle_clothes (bundle_id, clothe_id, position)
+SELECT bundle_clothes.bundle_id, clothe_duplicates.parent_id, bundle_clothes.position
+FROM bundle_clothes
+INNER JOIN clothe_duplicates ON clothe_duplicates.id = bundle_clothes.clothe_id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM bundle_clothes
+USING clothe_duplicates
+WHERE bundle_clothes.clothe_id = clothe_duplicates.id;
+
+INSERT INTO collection_clothes (collection_id, clothe_id, position)
+SELECT collection_clothes.collection_id, clothe_duplicates.parent_id, collection_clothes.position
+FROM collection_clothes
+INNER JOIN clothe_duplicates ON clothe_duplicates.id = collection_clothes.clothe_id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM collection_clothes
+USING clothe_duplicates
+WHERE collection_clothes.clothe_id = clothe_duplicates.id;
+
+-- The merged rows share the name of the parent, their translations only fill
+-- in the locales the parent lacks.
+INSERT INTO clothe_translations (clothe_id, locale, name, description)
+SELECT DISTINCT ON (clothe_duplicates.parent_id, clothe_translations.locale)
+       clothe_duplicates.parent_id, clothe_translations.locale, clothe_translations.name, clothe_translations.description
+FROM clothe_translations
+INNER JOIN clothe_duplicates ON clothe_duplicates.id = clothe_translations.clothe_id
+ORDER BY clothe_duplicates.parent_id, clothe_translations.locale, clothe_duplicates.id
+ON CONFLICT DO NOTHING;
+
+-- Related clothes are recomputed by the API server, the merged rows are only
+-- dropped from them.
+DELETE FROM related_clothes
+USING clothe_duplicates
+WHERE related_clothes.clothe_id = clothe_duplicates.id OR related_clothes.related_id = clothe_duplicates.id;
+
+-- The merged rows are archived rather than deleted so that carts, which are
+-- the purchase history, keep referring to what was bought.
+UPDATE clothes
+SET deleted_at = NOW()
+FROM clothe_duplicates
+WHERE clothes.id = clothe_duplicates.id;
+
+DROP TABLE clothe_duplicates;
@@ -0,0 +1,12 @@
+-- Actions on the account of a user, kept for data subject requests. actor_id
+-- is the admin who acted on the account, NULL when the user acted themselves.
+CREATE TABLE IF NOT EXISTS audit_entries (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    remote_addr text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_entries_user_id_idx ON audit_entries (user_id);